@@ -26,6 +26,12 @@ import (
 var benchCmd = &cli.Command{
 	Usage: "bench",
 	Short: "interop benchmark",
+	Long: `Benchmarks byte stream throughput against an interop service.
+
+The window of each channel starts small and is auto-tuned unless
+QTALK_WINDOW=fixed is set. QTALK_LATENCY simulates a link with the
+given latency in each direction, such as QTALK_LATENCY=20ms, to
+measure how throughput holds up with a longer round trip.`,
 	Run: func(ctx context.Context, args []string) {
 		log.SetOutput(os.Stderr)
 
@@ -35,6 +41,23 @@ var benchCmd = &cli.Command{
 			c = codec.JSONCodec{}
 		}
 
+		sessCfg := mux.Config{
+			WindowSize:    256 << 10,
+			MaxWindowSize: 64 << 20,
+		}
+		if os.Getenv("QTALK_WINDOW") == "fixed" {
+			log.Println("* Using fixed window")
+			sessCfg.MaxWindowSize = 0
+		}
+
+		var latency time.Duration
+		if v := os.Getenv("QTALK_LATENCY"); v != "" {
+			var err error
+			latency, err = time.ParseDuration(v)
+			fatal(err)
+			log.Println("* Simulating", latency, "latency")
+		}
+
 		var cmd *exec.Cmd
 		var sess mux.Session
 
@@ -60,7 +83,11 @@ var benchCmd = &cli.Command{
 			if err != nil {
 				fatal(err)
 			}
-			sess, err = mux.DialIO(wc, rc)
+			if latency > 0 {
+				wc = delayWrites(wc, latency)
+				rc = delayReads(rc, latency)
+			}
+			sess, err = mux.DialIOWithConfig(wc, rc, sessCfg)
 			if err != nil {
 				fatal(err)
 			}
@@ -95,7 +122,12 @@ var benchCmd = &cli.Command{
 		// Bytes check
 		// 1mb
 		mb := 1 << 20
-		for _, v := range []int{mb * 256, mb * 512, mb * 1024} {
+		sizes := []int{mb * 256, mb * 512, mb * 1024}
+		if latency > 0 {
+			// keep runs short while the window is limited by latency
+			sizes = []int{mb * 16, mb * 32, mb * 64}
+		}
+		for _, v := range sizes {
 			data := make([]byte, v)
 			rand.Read(data)
 			start := time.Now()
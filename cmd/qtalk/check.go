@@ -67,7 +67,7 @@ var checkCmd = &cli.Command{
 			if err != nil {
 				fatal(err)
 			}
-			sess, err = mux.DialIO(wc, rc)
+			sess, err = mux.DialIOWithConfig(wc, rc, captureConfig())
 			if err != nil {
 				fatal(err)
 			}
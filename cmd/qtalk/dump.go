@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/progrium/qtalk-go/cmd/qtalk/cli"
+	"github.com/progrium/qtalk-go/codec"
+	"github.com/progrium/qtalk-go/mux"
+	"github.com/progrium/qtalk-go/mux/capture"
+	"github.com/progrium/qtalk-go/mux/frame"
+	"github.com/progrium/qtalk-go/rpc"
+	cbor "github.com/progrium/qtalk-go/x/cbor/codec"
+)
+
+var (
+	dumpCodec  string
+	dumpReplay bool
+)
+
+var dumpCmd = &cli.Command{
+	Usage: "dump [-codec json|cbor] [-replay] <capture>",
+	Short: "inspect a session capture",
+	Long: `Dumps the frames of a session capture, such as one recorded by
+setting QTALK_CAPTURE=<file> when running check, then the data of each
+channel reassembled from its frames. With -codec, channel data is decoded
+as RPC calls and responses using the given codec.
+
+With -replay, the frames the capture received are replayed into a new
+session to reproduce how it handled them.`,
+	Args: cli.MinArgs(1),
+	Run: func(ctx context.Context, args []string) {
+		log.SetOutput(os.Stderr)
+
+		var c codec.Codec
+		switch dumpCodec {
+		case "":
+		case "json":
+			c = codec.JSONCodec{}
+		case "cbor":
+			c = cbor.CBORCodec{}
+		default:
+			log.Fatalf("unknown codec: %s", dumpCodec)
+		}
+
+		f, err := os.Open(args[0])
+		fatal(err)
+		defer f.Close()
+		r, err := capture.NewReader(f)
+		fatal(err)
+
+		if dumpReplay {
+			replayCapture(r)
+			return
+		}
+
+		d := newDumper()
+		for {
+			rec, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			fatal(err)
+			msg, err := rec.Message()
+			fatal(err)
+			fmt.Printf("%12s %-8s %s\n", rec.Time.Sub(r.Start()), rec.Direction, msg)
+			d.add(rec.Direction, msg)
+		}
+		for _, ch := range d.chans {
+			fmt.Println()
+			ch.dump(c)
+		}
+	},
+}
+
+func init() {
+	dumpCmd.Flags().StringVar(&dumpCodec, "codec", "", "decode channel data as RPC using codec (json or cbor)")
+	dumpCmd.Flags().BoolVar(&dumpReplay, "replay", false, "replay the received frames into a session")
+}
+
+// captureConfig returns a session config that records the
+// session to the file named by QTALK_CAPTURE, if it is set.
+func captureConfig() mux.Config {
+	var cfg mux.Config
+	path := os.Getenv("QTALK_CAPTURE")
+	if path == "" {
+		return cfg
+	}
+	f, err := os.Create(path)
+	fatal(err)
+	w, err := capture.NewWriter(f)
+	fatal(err)
+	log.Println("* Capturing session to", path)
+	cfg.Capture = w
+	return cfg
+}
+
+// dumpChannel is a channel reassembled from a capture.
+type dumpChannel struct {
+	id       uint32 // the capturing session's ID for the channel
+	byPeer   bool   // whether the peer opened the channel
+	chanType string
+	sent     bytes.Buffer
+	received bytes.Buffer
+}
+
+// dumper follows the channels of a capture.
+type dumper struct {
+	chans []*dumpChannel
+	// open maps the capturing session's IDs to its open channels.
+	open map[uint32]*dumpChannel
+	// peer maps the peer's IDs to the capturing session's.
+	peer map[uint32]uint32
+	// opening holds the channel types of opens waiting on
+	// a confirm, by the ID of the end that sent the open.
+	opening map[capture.Direction]map[uint32]string
+}
+
+func newDumper() *dumper {
+	return &dumper{
+		open: make(map[uint32]*dumpChannel),
+		peer: make(map[uint32]uint32),
+		opening: map[capture.Direction]map[uint32]string{
+			capture.Sent:     make(map[uint32]string),
+			capture.Received: make(map[uint32]string),
+		},
+	}
+}
+
+func (d *dumper) add(dir capture.Direction, msg frame.Message) {
+	switch m := msg.(type) {
+	case *frame.OpenMessage:
+		d.opening[dir][m.SenderID] = m.ChannelType
+		return
+	case *frame.OpenConfirmMessage:
+		// the confirm is sent by the end that didn't open the channel
+		ch := &dumpChannel{id: m.ChannelID, byPeer: dir == capture.Sent}
+		peerID := m.SenderID
+		if ch.byPeer {
+			ch.id, peerID = m.SenderID, m.ChannelID
+			ch.chanType = d.opening[capture.Received][peerID]
+			delete(d.opening[capture.Received], peerID)
+		} else {
+			ch.chanType = d.opening[capture.Sent][ch.id]
+			delete(d.opening[capture.Sent], ch.id)
+		}
+		d.chans = append(d.chans, ch)
+		d.open[ch.id] = ch
+		d.peer[peerID] = ch.id
+		return
+	case *frame.DataMessage:
+		id := m.ChannelID
+		if dir == capture.Sent {
+			id = d.peer[id]
+		}
+		ch := d.open[id]
+		if ch == nil {
+			return
+		}
+		if dir == capture.Sent {
+			ch.sent.Write(m.Data)
+		} else {
+			ch.received.Write(m.Data)
+		}
+	}
+}
+
+func (ch *dumpChannel) dump(c codec.Codec) {
+	opener := "local"
+	if ch.byPeer {
+		opener = "peer"
+	}
+	fmt.Printf("channel %d opened by %s", ch.id, opener)
+	if ch.chanType != "" {
+		fmt.Printf(" type %q", ch.chanType)
+	}
+	fmt.Printf(": sent %d bytes, received %d bytes\n", ch.sent.Len(), ch.received.Len())
+
+	if c == nil {
+		dumpBytes("sent", ch.sent.Bytes())
+		dumpBytes("received", ch.received.Bytes())
+		return
+	}
+	calls, responses := &ch.sent, &ch.received
+	if ch.byPeer {
+		calls, responses = responses, calls
+	}
+	dumpRPC("call", calls, &rpc.CallHeader{}, c)
+	dumpRPC("response", responses, &rpc.ResponseHeader{}, c)
+}
+
+// dumpBytes prints a preview of data.
+func dumpBytes(name string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	const preview = 64
+	if len(data) > preview {
+		fmt.Printf("  %s: %q...\n", name, data[:preview])
+		return
+	}
+	fmt.Printf("  %s: %q\n", name, data)
+}
+
+// dumpRPC decodes and prints the header and values framed in data, then
+// a preview of what is left if it isn't all framed values, such as the
+// bytes streamed after a response that continues the channel.
+func dumpRPC(name string, data *bytes.Buffer, header any, c codec.Codec) {
+	if data.Len() == 0 {
+		return
+	}
+	dec := (&rpc.FrameCodec{Codec: c}).Decoder(data)
+	if err := dec.Decode(header); err != nil {
+		dumpBytes(name, data.Bytes())
+		return
+	}
+	fmt.Printf("  %s: %s\n", name, dumpValue(header))
+	for data.Len() > 0 {
+		rest := data.Bytes()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			dumpBytes(name+" data", rest)
+			return
+		}
+		fmt.Printf("  %s value: %s\n", name, dumpValue(v))
+	}
+}
+
+func dumpValue(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// replayCapture replays the frames r received into a session, printing
+// the frames it sends and receives and the data of its channels.
+func replayCapture(r *capture.Reader) {
+	var cfg mux.Config
+	cfg.Capture = framePrinter{start: time.Now()}
+	sess := mux.NewWithConfig(capture.Replay(r, false), cfg)
+	defer sess.Close()
+
+	var wg sync.WaitGroup
+	accepting := make(chan struct{})
+	go func() {
+		defer close(accepting)
+		for {
+			ch, err := sess.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n, err := io.Copy(io.Discard, ch)
+				log.Printf("* Channel read %d bytes: %v", n, err)
+				ch.Close()
+			}()
+		}
+	}()
+	err := sess.Wait()
+	// channels are only added while accepting, so wait for that to stop
+	<-accepting
+	wg.Wait()
+	log.Println("* Replay ended:", err)
+}
+
+// framePrinter prints the frames of a session.
+type framePrinter struct {
+	start time.Time
+}
+
+func (p framePrinter) CaptureFrame(sent bool, msg frame.Message) {
+	dir := capture.Received
+	if sent {
+		dir = capture.Sent
+	}
+	fmt.Printf("%12s %-8s %s\n", time.Since(p.start), dir, msg)
+}
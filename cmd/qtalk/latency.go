@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// latencyWriter delays everything written to w by a fixed latency
+// without limiting bandwidth, simulating a network link.
+type latencyWriter struct {
+	w       io.WriteCloser
+	latency time.Duration
+	queue   chan delayedWrite
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type delayedWrite struct {
+	at   time.Time
+	data []byte
+}
+
+func delayWrites(w io.WriteCloser, latency time.Duration) io.WriteCloser {
+	lw := &latencyWriter{
+		w:       w,
+		latency: latency,
+		queue:   make(chan delayedWrite, 1<<16),
+	}
+	go func() {
+		for d := range lw.queue {
+			time.Sleep(time.Until(d.at))
+			if _, err := lw.w.Write(d.data); err != nil {
+				break
+			}
+		}
+		lw.w.Close()
+	}()
+	return lw
+}
+
+func (lw *latencyWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	lw.queue <- delayedWrite{at: time.Now().Add(lw.latency), data: append([]byte(nil), p...)}
+	return len(p), nil
+}
+
+func (lw *latencyWriter) Close() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if !lw.closed {
+		lw.closed = true
+		close(lw.queue)
+	}
+	return nil
+}
+
+// delayReads delays everything read from r by a fixed latency.
+func delayReads(r io.Reader, latency time.Duration) io.ReadCloser {
+	pr, pw := io.Pipe()
+	w := delayWrites(pw, latency)
+	go func() {
+		io.Copy(w, r)
+		w.Close()
+	}()
+	return pr
+}
@@ -18,6 +18,7 @@ func main() {
 	root.AddCommand(interopCmd)
 	root.AddCommand(checkCmd)
 	root.AddCommand(benchCmd)
+	root.AddCommand(dumpCmd)
 
 	if err := cli.Execute(context.Background(), root, os.Args[1:]); err != nil {
 		fatal(err)
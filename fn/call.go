@@ -5,6 +5,7 @@ import (
 	"reflect"
 
 	"github.com/mitchellh/mapstructure"
+	"github.com/progrium/qtalk-go/rpc"
 )
 
 var errorInterface = reflect.TypeOf((*error)(nil)).Elem()
@@ -15,7 +16,7 @@ var errorInterface = reflect.TypeOf((*error)(nil)).Elem()
 func Call(fn any, args []any) (_ []any, err error) {
 	defer func() {
 		if p := recover(); p != nil {
-			err = fmt.Errorf("panic: %s [%s]", p, identifyPanic())
+			err = rpc.Errorf(rpc.Internal, "panic: %s [%s]", p, identifyPanic())
 		}
 	}()
 	fnval := reflect.ValueOf(fn)
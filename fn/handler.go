@@ -88,13 +88,13 @@ func fromFunc(fn reflect.Value) rpc.Handler {
 	return rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
 		defer func() {
 			if p := recover(); p != nil {
-				r.Return(fmt.Errorf("panic: %s [%s]", p, identifyPanic()))
+				r.Return(rpc.Errorf(rpc.Internal, "panic: %s [%s]", p, identifyPanic()))
 			}
 		}()
 
 		var params []any
 		if err := c.Receive(&params); err != nil {
-			r.Return(fmt.Errorf("fn: args: %s", err.Error()))
+			r.Return(rpc.Errorf(rpc.InvalidArgument, "fn: args: %s", err.Error()))
 			return
 		}
 		if expectsCallParam {
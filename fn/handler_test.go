@@ -160,6 +160,19 @@ func TestHandlerFromFunc(t *testing.T) {
 		}
 	})
 
+	t.Run("panic", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(a, b int) int {
+			panic("boom")
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		var sum int
+		_, err := client.Call(context.Background(), "", []interface{}{2, 3}, &sum)
+		if !errors.Is(err, rpc.Internal) || !strings.Contains(err.Error(), "boom") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
 	t.Run("no return", func(t *testing.T) {
 		client, _ := rpctest.NewPair(HandlerFrom(func(a, b int) {
 			return
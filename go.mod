@@ -3,6 +3,7 @@ module github.com/progrium/qtalk-go
 go 1.18
 
 require (
+	github.com/flynn/noise v1.1.0
 	github.com/mitchellh/mapstructure v1.4.3
 	github.com/rs/xid v1.3.0
 	golang.org/x/net v0.14.0
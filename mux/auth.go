@@ -0,0 +1,246 @@
+package mux
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrAuthFailed is returned when the authentication handshake of a session
+// fails because either end rejected the other.
+var ErrAuthFailed = errors.New("qmux: authentication failed")
+
+// ErrAuthTimeout is returned by Wait when a session was closed because
+// the handshakes took longer than Config.AuthTimeout.
+var ErrAuthTimeout = errors.New("qmux: authentication timed out")
+
+// An Authenticator runs an authentication handshake over the transport of a
+// new session before any frames are exchanged. Dialing and accepting ends of
+// a transport use matching client and server Authenticators.
+type Authenticator interface {
+	// Authenticate runs the handshake and returns the principal the other
+	// end authenticated as, which may be empty if the other end doesn't
+	// authenticate itself. Returning an error closes the session.
+	Authenticate(rw io.ReadWriter) (principal string, err error)
+}
+
+// A Handshaker runs a handshake over the transport of a new session before
+// any frames are exchanged, returning the transport the session runs over.
+// It is used to layer encryption over transports that don't have their own.
+type Handshaker interface {
+	Handshake(t io.ReadWriteCloser) (io.ReadWriteCloser, error)
+}
+
+// An Authenticated is a Session that ran an authentication handshake.
+type Authenticated interface {
+	// Principal returns the principal the other end authenticated as. It
+	// is empty until the handshake has finished.
+	Principal() string
+}
+
+const (
+	authOK     byte = 0
+	authFailed byte = 1
+
+	// maxAuthStringLength limits the size of strings sent during
+	// the handshake, before the other end is trusted.
+	maxAuthStringLength = 1 << 12
+
+	nonceSize = 32
+)
+
+// HMACClientAuth authenticates the dialing end of a session with a shared
+// secret. The server sends a random challenge, and each end proves it has
+// the secret for ID by returning an HMAC-SHA256 of both ends' challenges,
+// so the secret itself is never sent. Authenticate returns ID as the
+// principal of the server.
+type HMACClientAuth struct {
+	ID     string
+	Secret []byte
+}
+
+// HMACServerAuth authenticates the accepting end of a session against
+// HMACClientAuth. Keys maps each ID a client can authenticate as to its
+// shared secret, and the ID is returned as the principal of the client.
+type HMACServerAuth struct {
+	Keys map[string][]byte
+}
+
+func (a HMACClientAuth) Authenticate(rw io.ReadWriter) (string, error) {
+	clientNonce, err := newNonce()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	writeAuthString(&buf, []byte(a.ID))
+	buf.Write(clientNonce)
+	if _, err := rw.Write(buf.Bytes()); err != nil {
+		return "", err
+	}
+
+	serverNonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rw, serverNonce); err != nil {
+		return "", err
+	}
+	serverProof := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(rw, serverProof); err != nil {
+		return "", err
+	}
+	if !hmac.Equal(serverProof, hmacProof(a.Secret, "server", a.ID, clientNonce, serverNonce)) {
+		return "", fmt.Errorf("%w: server proof mismatch", ErrAuthFailed)
+	}
+
+	if _, err := rw.Write(hmacProof(a.Secret, "client", a.ID, clientNonce, serverNonce)); err != nil {
+		return "", err
+	}
+	if err := readAuthStatus(rw); err != nil {
+		return "", err
+	}
+	return a.ID, nil
+}
+
+func (a HMACServerAuth) Authenticate(rw io.ReadWriter) (string, error) {
+	id, err := readAuthString(rw)
+	if err != nil {
+		return "", err
+	}
+	clientNonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rw, clientNonce); err != nil {
+		return "", err
+	}
+	serverNonce, err := newNonce()
+	if err != nil {
+		return "", err
+	}
+
+	// An unknown ID gets a proof made with a random secret, so the client
+	// fails the same way it would with the wrong secret.
+	secret, known := a.Keys[string(id)]
+	if !known {
+		if secret, err = newNonce(); err != nil {
+			return "", err
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(serverNonce)
+	buf.Write(hmacProof(secret, "server", string(id), clientNonce, serverNonce))
+	if _, err := rw.Write(buf.Bytes()); err != nil {
+		return "", err
+	}
+
+	clientProof := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(rw, clientProof); err != nil {
+		return "", err
+	}
+	if !known || !hmac.Equal(clientProof, hmacProof(secret, "client", string(id), clientNonce, serverNonce)) {
+		rw.Write([]byte{authFailed})
+		return "", ErrAuthFailed
+	}
+	if _, err := rw.Write([]byte{authOK}); err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
+
+// hmacProof returns the proof sent by the given side of the handshake.
+// The side is included so that one end's proof can't be reflected back
+// to it as the other end's.
+func hmacProof(secret []byte, side, id string, clientNonce, serverNonce []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("qtalk-hmac-" + side))
+	writeAuthString(mac, []byte(id))
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+	return mac.Sum(nil)
+}
+
+// TokenClientAuth authenticates the dialing end of a session by sending a
+// bearer token. The token is sent as is, so the transport should be
+// encrypted. The server doesn't authenticate itself, so Authenticate
+// returns an empty principal.
+type TokenClientAuth struct {
+	Token string
+}
+
+// TokenServerAuth authenticates the accepting end of a session against
+// TokenClientAuth. Verify is called with the token sent by the client and
+// returns the principal it belongs to, or an error to reject the session.
+type TokenServerAuth struct {
+	Verify func(token string) (principal string, err error)
+}
+
+func (a TokenClientAuth) Authenticate(rw io.ReadWriter) (string, error) {
+	var buf bytes.Buffer
+	writeAuthString(&buf, []byte(a.Token))
+	if _, err := rw.Write(buf.Bytes()); err != nil {
+		return "", err
+	}
+	return "", readAuthStatus(rw)
+}
+
+func (a TokenServerAuth) Authenticate(rw io.ReadWriter) (string, error) {
+	token, err := readAuthString(rw)
+	if err != nil {
+		return "", err
+	}
+	if a.Verify == nil {
+		rw.Write([]byte{authFailed})
+		return "", ErrAuthFailed
+	}
+	principal, err := a.Verify(string(token))
+	if err != nil {
+		rw.Write([]byte{authFailed})
+		return "", fmt.Errorf("%w: %v", ErrAuthFailed, err)
+	}
+	if _, err := rw.Write([]byte{authOK}); err != nil {
+		return "", err
+	}
+	return principal, nil
+}
+
+func newNonce() ([]byte, error) {
+	b := make([]byte, nonceSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readAuthStatus reads the byte the server ends the handshake with.
+func readAuthStatus(r io.Reader) error {
+	var status [1]byte
+	if _, err := io.ReadFull(r, status[:]); err != nil {
+		return err
+	}
+	if status[0] != authOK {
+		return fmt.Errorf("%w: rejected by server", ErrAuthFailed)
+	}
+	return nil
+}
+
+// readAuthString reads a length prefixed byte string.
+func readAuthString(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxAuthStringLength {
+		return nil, fmt.Errorf("qmux: auth string too long: %d bytes", length)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// writeAuthString writes a length prefixed byte string.
+func writeAuthString(w io.Writer, b []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(b)))
+	w.Write(b)
+}
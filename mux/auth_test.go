@@ -0,0 +1,109 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newAuthSessions(t *testing.T, client, server Authenticator) (Session, Session) {
+	t.Helper()
+	a, b := net.Pipe()
+	sessA := NewWithConfig(a, Config{Authenticator: client})
+	sessB := NewWithConfig(b, Config{Authenticator: server})
+	t.Cleanup(func() {
+		sessA.Close()
+		sessB.Close()
+	})
+	return sessA, sessB
+}
+
+func TestAuth(t *testing.T) {
+	hmacServer := HMACServerAuth{Keys: map[string][]byte{
+		"alice": []byte("alice secret"),
+	}}
+	tokenServer := TokenServerAuth{Verify: func(token string) (string, error) {
+		if token != "letmein" {
+			return "", errors.New("bad token")
+		}
+		return "bob", nil
+	}}
+
+	t.Run("accepted", func(t *testing.T) {
+		for _, tt := range []struct {
+			name            string
+			client, server  Authenticator
+			clientPrincipal string
+			serverPrincipal string
+		}{
+			{"hmac", HMACClientAuth{ID: "alice", Secret: []byte("alice secret")}, hmacServer, "alice", "alice"},
+			{"token", TokenClientAuth{Token: "letmein"}, tokenServer, "", "bob"},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				sessA, sessB := newAuthSessions(t, tt.client, tt.server)
+				chA, chB := openPair(t, sessA, sessB)
+				go func() {
+					chA.Write([]byte("hello"))
+					chA.Close()
+				}()
+				b, err := io.ReadAll(chB)
+				fatal(err, t)
+				if string(b) != "hello" {
+					t.Fatalf("unexpected data: %q", b)
+				}
+
+				if p := sessA.(Authenticated).Principal(); p != tt.clientPrincipal {
+					t.Fatalf("unexpected client principal: %q", p)
+				}
+				if p := sessB.(Authenticated).Principal(); p != tt.serverPrincipal {
+					t.Fatalf("unexpected server principal: %q", p)
+				}
+			})
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		for _, tt := range []struct {
+			name           string
+			client, server Authenticator
+		}{
+			{"hmac wrong secret", HMACClientAuth{ID: "alice", Secret: []byte("guess")}, hmacServer},
+			{"hmac unknown id", HMACClientAuth{ID: "mallory", Secret: []byte("alice secret")}, hmacServer},
+			{"token", TokenClientAuth{Token: "guess"}, tokenServer},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				sessA, sessB := newAuthSessions(t, tt.client, tt.server)
+
+				accepted := make(chan error, 1)
+				go func() {
+					_, err := sessB.Accept()
+					accepted <- err
+				}()
+				if _, err := sessA.Open(context.Background()); !errors.Is(err, ErrAuthFailed) {
+					t.Fatalf("unexpected open error: %v", err)
+				}
+				if err := <-accepted; err != io.EOF {
+					t.Fatalf("unexpected accept error: %v", err)
+				}
+				if p := sessB.(Authenticated).Principal(); p != "" {
+					t.Fatalf("unexpected principal: %q", p)
+				}
+			})
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer a.Close()
+		sess := NewWithConfig(b, Config{
+			Authenticator: hmacServer,
+			AuthTimeout:   50 * time.Millisecond,
+		})
+		if err := sess.Wait(); err != ErrAuthTimeout {
+			t.Fatalf("unexpected wait error: %v", err)
+		}
+	})
+}
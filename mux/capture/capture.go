@@ -0,0 +1,213 @@
+// Package capture records the frames of mux sessions to files, reads them
+// back for inspection and replays them into sessions to reproduce bugs.
+//
+// Set a Writer as the Capture of a session's config to record every frame
+// it sends and receives with the time it was seen. Unlike frame.Debug, this
+// is done per session and the capture keeps the frames themselves.
+//
+// A capture starts with an 8 byte magic and version, followed by the start
+// time in nanoseconds since the Unix epoch as a big endian int64. Each frame
+// is then recorded as a direction byte, the nanoseconds since the previous
+// record as a uvarint, the length of the frame as a uvarint and the encoded
+// frame.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+// ErrFormat is returned reading a file that isn't a valid capture.
+var ErrFormat = errors.New("capture: invalid capture format")
+
+const (
+	magic = "qmuxcap\x01"
+
+	// maxFrameLen is the largest frame a capture can hold, a data frame
+	// with the largest payload its length field allows.
+	maxFrameLen = 9 + math.MaxUint32
+)
+
+// Direction is whether a captured frame was sent or received.
+type Direction byte
+
+const (
+	Received Direction = iota
+	Sent
+)
+
+func (d Direction) String() string {
+	if d == Sent {
+		return "sent"
+	}
+	return "received"
+}
+
+// Record is a frame captured from a session.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+
+	// Frame is the encoded frame.
+	Frame []byte
+}
+
+// Message decodes the frame of the record.
+func (r *Record) Message() (frame.Message, error) {
+	dec := frame.NewDecoder(bytes.NewReader(r.Frame))
+	dec.MaxDataLength = math.MaxUint32
+	return dec.Decode()
+}
+
+// Writer writes frames to a capture. It implements mux.FrameCapturer, so a
+// session can be recorded by setting its Config.Capture to a Writer. It is
+// safe for concurrent use.
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	last time.Time
+	buf  [1 + 2*binary.MaxVarintLen64]byte
+	err  error
+}
+
+// NewWriter writes the header of a capture starting now to w, and returns
+// a Writer for its records.
+func NewWriter(w io.Writer) (*Writer, error) {
+	// times are compared by wall clock, since that is what is recorded
+	now := time.Now().Round(0)
+	var header [16]byte
+	copy(header[:], magic)
+	binary.BigEndian.PutUint64(header[8:], uint64(now.UnixNano()))
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, last: now}, nil
+}
+
+// CaptureFrame records a frame sent or received by a session. Errors
+// writing the capture are returned by Err.
+func (w *Writer) CaptureFrame(sent bool, msg frame.Message) {
+	r := Record{Direction: Received, Frame: msg.Bytes()}
+	if sent {
+		r.Direction = Sent
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r.Time = time.Now()
+	w.write(r)
+}
+
+// WriteRecord writes a record to the capture. Records timed before the
+// previous one are recorded at the time of the previous one.
+func (w *Writer) WriteRecord(r Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(r)
+}
+
+// Err returns the first error writing the capture, if any.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Writer) write(r Record) error {
+	if w.err != nil {
+		return w.err
+	}
+	var delta time.Duration
+	if t := r.Time.Round(0); t.After(w.last) {
+		delta = t.Sub(w.last)
+		w.last = t
+	}
+	w.buf[0] = byte(r.Direction)
+	n := 1 + binary.PutUvarint(w.buf[1:], uint64(delta))
+	n += binary.PutUvarint(w.buf[n:], uint64(len(r.Frame)))
+	if _, w.err = w.w.Write(w.buf[:n]); w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(r.Frame)
+	return w.err
+}
+
+// Reader reads the records of a capture.
+type Reader struct {
+	r     *bufio.Reader
+	start time.Time
+	last  time.Time
+}
+
+// NewReader reads the header of a capture from r, and returns
+// a Reader for its records.
+func NewReader(r io.Reader) (*Reader, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrFormat
+		}
+		return nil, err
+	}
+	if string(header[:8]) != magic {
+		return nil, ErrFormat
+	}
+	start := time.Unix(0, int64(binary.BigEndian.Uint64(header[8:])))
+	return &Reader{r: bufio.NewReader(r), start: start, last: start}, nil
+}
+
+// Start returns the time the capture was started.
+func (r *Reader) Start() time.Time {
+	return r.start
+}
+
+// Next returns the next record of the capture, or io.EOF
+// once all have been read.
+func (r *Reader) Next() (*Record, error) {
+	dir, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if Direction(dir) != Received && Direction(dir) != Sent {
+		return nil, fmt.Errorf("%w: unknown direction %d", ErrFormat, dir)
+	}
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if n > maxFrameLen {
+		return nil, fmt.Errorf("%w: %d byte frame", ErrFormat, n)
+	}
+	// the frame buffer grows as it is read, rather than trusting
+	// the length before it has been read
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r.r, int64(n)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	r.last = r.last.Add(time.Duration(delta))
+	return &Record{
+		Time:      r.last,
+		Direction: Direction(dir),
+		Frame:     buf.Bytes(),
+	}, nil
+}
+
+// unexpectedEOF reports a capture that ends part way through a record.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
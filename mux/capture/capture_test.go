@@ -0,0 +1,199 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux"
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+func fatal(err error, t *testing.T) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	fatal(err, t)
+
+	msgs := []frame.Message{
+		frame.OpenMessage{SenderID: 1, WindowSize: 1024, MaxPacketSize: 512},
+		frame.DataMessage{ChannelID: 2, Length: 5, Data: []byte("hello")},
+		frame.CloseMessage{ChannelID: 2},
+	}
+	for i, msg := range msgs {
+		w.CaptureFrame(i%2 == 1, msg)
+	}
+	later := time.Now().Add(time.Second)
+	fatal(w.WriteRecord(Record{Time: later, Direction: Sent, Frame: frame.EOFMessage{ChannelID: 3}.Bytes()}), t)
+	fatal(w.Err(), t)
+
+	r, err := NewReader(&buf)
+	fatal(err, t)
+	last := r.Start()
+	for i, want := range append(msgs, frame.EOFMessage{ChannelID: 3}) {
+		rec, err := r.Next()
+		fatal(err, t)
+		if dir := rec.Direction == Sent; dir != (i%2 == 1) {
+			t.Fatalf("record %d has direction %s", i, rec.Direction)
+		}
+		if rec.Time.Before(last) {
+			t.Fatalf("record %d is timed before the one before it", i)
+		}
+		last = rec.Time
+		msg, err := rec.Message()
+		fatal(err, t)
+		if !bytes.Equal(msg.Bytes(), want.Bytes()) {
+			t.Fatalf("record %d is %s, expected %s", i, msg, want)
+		}
+	}
+	if !last.Equal(later) {
+		t.Fatalf("last record is timed %s, expected %s", last, later)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, but got: %v", err)
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("not a capture file"))); !errors.Is(err, ErrFormat) {
+		t.Fatalf("expected ErrFormat, but got: %v", err)
+	}
+}
+
+// captureSessions returns a session captured to buf and its peer.
+func captureSessions(t *testing.T, buf *bytes.Buffer) (mux.Session, mux.Session) {
+	t.Helper()
+	w, err := NewWriter(buf)
+	fatal(err, t)
+	a, b := net.Pipe()
+	sessA := mux.NewWithConfig(a, mux.Config{Capture: w})
+	sessB := mux.New(b)
+	t.Cleanup(func() {
+		sessA.Close()
+		sessB.Close()
+	})
+	return sessA, sessB
+}
+
+// openPair opens a channel from sessA and accepts it on sessB.
+func openPair(t *testing.T, sessA, sessB mux.Session) (mux.Channel, mux.Channel) {
+	t.Helper()
+	accepted := make(chan mux.Channel, 1)
+	go func() {
+		ch, err := sessB.Accept()
+		fatal(err, t)
+		accepted <- ch
+	}()
+	ch, err := sessA.Open(context.Background())
+	fatal(err, t)
+	return ch, <-accepted
+}
+
+// send writes msg to ch and closes it, and waits until the other end
+// has read it all.
+func send(t *testing.T, ch, other mux.Channel, msg string) {
+	t.Helper()
+	go func() {
+		_, err := ch.Write([]byte(msg))
+		fatal(err, t)
+		fatal(ch.Close(), t)
+	}()
+	b, err := io.ReadAll(other)
+	fatal(err, t)
+	if string(b) != msg {
+		t.Fatalf("unexpected data: %q", b)
+	}
+}
+
+func TestCaptureSession(t *testing.T) {
+	var buf bytes.Buffer
+	sessA, sessB := captureSessions(t, &buf)
+	chA, chB := openPair(t, sessA, sessB)
+	send(t, chB, chA, "hello")
+	fatal(sessA.Close(), t)
+	sessB.Wait()
+
+	r, err := NewReader(&buf)
+	fatal(err, t)
+	var sent, received []string
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		fatal(err, t)
+		msg, err := rec.Message()
+		fatal(err, t)
+		name := fmt.Sprintf("%T", msg)
+		if rec.Direction == Sent {
+			sent = append(sent, name)
+		} else {
+			received = append(received, name)
+		}
+		if data, ok := msg.(*frame.DataMessage); ok && string(data.Data) != "hello" {
+			t.Fatalf("unexpected data captured: %q", data.Data)
+		}
+	}
+	if !contains(sent, "*frame.OpenMessage") || !contains(received, "*frame.OpenConfirmMessage") {
+		t.Fatalf("channel open was not captured: sent=%v received=%v", sent, received)
+	}
+	if !contains(received, "*frame.DataMessage") || !contains(received, "*frame.CloseMessage") {
+		t.Fatalf("channel data was not captured: received=%v", received)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	sessA, sessB := captureSessions(t, &buf)
+
+	// a channel opened by the captured session is skipped by the replay,
+	// and is left open so the peer's channel gets the next ID
+	chA, chB := openPair(t, sessA, sessB)
+	go func() {
+		_, err := chB.Write([]byte("ignored"))
+		fatal(err, t)
+	}()
+	_, err := io.ReadFull(chA, make([]byte, 7))
+	fatal(err, t)
+
+	// the peer's channel gets a different ID when replayed, since the
+	// replaying session hasn't opened one of its own first
+	chB, chA = openPair(t, sessB, sessA)
+	send(t, chB, chA, "hello")
+	fatal(sessA.Close(), t)
+	sessB.Wait()
+
+	r, err := NewReader(&buf)
+	fatal(err, t)
+	sess := mux.New(Replay(r, false))
+	defer sess.Close()
+
+	ch, err := sess.Accept()
+	fatal(err, t)
+	b, err := io.ReadAll(ch)
+	fatal(err, t)
+	if string(b) != "hello" {
+		t.Fatalf("unexpected data replayed: %q", b)
+	}
+	if err := sess.Wait(); err != io.EOF {
+		t.Fatalf("expected the replay to end with io.EOF, but got: %v", err)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+// Replay returns a transport that plays back the frames a capture received,
+// so a session run over it sees what the capturing session saw from its peer.
+// If realtime is true, frames are delivered with the timing they were
+// captured with, otherwise as fast as they are read.
+//
+// Frames the replaying session writes are read and discarded, but they are
+// used to follow the channels it opens, since its channel IDs may not match
+// the capturing session's. Frames of channels the capturing session opened
+// itself are skipped, since the replaying session doesn't open them. Frames
+// for channels the capture never opened are replayed as they were.
+//
+// Data is replayed without waiting for the replaying session to adjust the
+// window of its channels, so their windows should be at least as large as
+// those of the capturing session.
+func Replay(r *Reader, realtime bool) io.ReadWriteCloser {
+	pr, pw := io.Pipe()
+	rp := &replay{
+		r:        r,
+		realtime: realtime,
+		pw:       pw,
+		own:      make(map[uint32]bool),
+		peer:     make(map[uint32]uint32),
+		local:    make(map[uint32]uint32),
+		refused:  make(map[uint32]bool),
+	}
+	rp.cond = sync.NewCond(&rp.mu)
+	go rp.readWrites(pr)
+	return rp
+}
+
+// replay is the transport returned by Replay.
+type replay struct {
+	r        *Reader
+	realtime bool
+	began    time.Time
+	pending  []byte
+
+	pw *io.PipeWriter
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	closed bool
+
+	// own is the set of channel IDs the capturing session opened.
+	own map[uint32]bool
+	// peer maps the capturing session's ID for a channel the peer
+	// opened to the peer's ID for it.
+	peer map[uint32]uint32
+	// local maps the peer's ID for a channel to the replaying
+	// session's ID for it, once the replaying session confirms it.
+	local map[uint32]uint32
+	// refused is the set of peer channel IDs the replaying
+	// session failed to open.
+	refused map[uint32]bool
+}
+
+func (rp *replay) Read(p []byte) (int, error) {
+	for len(rp.pending) == 0 {
+		rec, err := rp.r.Next()
+		if err != nil {
+			return 0, err
+		}
+		if rp.realtime {
+			if rp.began.IsZero() {
+				rp.began = time.Now()
+			}
+			time.Sleep(time.Until(rp.began.Add(rec.Time.Sub(rp.r.Start()))))
+		}
+		rp.pending, err = rp.next(rec)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, rp.pending)
+	rp.pending = rp.pending[n:]
+	return n, nil
+}
+
+// next follows the channels opened in rec and returns the frame to deliver
+// for it, if any.
+func (rp *replay) next(rec *Record) ([]byte, error) {
+	msg, err := rec.Message()
+	if err != nil {
+		return nil, err
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.closed {
+		return nil, net.ErrClosed
+	}
+
+	if rec.Direction == Sent {
+		switch m := msg.(type) {
+		case *frame.OpenMessage:
+			rp.own[m.SenderID] = true
+			delete(rp.peer, m.SenderID)
+		case *frame.OpenConfirmMessage:
+			delete(rp.own, m.SenderID)
+			rp.peer[m.SenderID] = m.ChannelID
+		}
+		return nil, nil
+	}
+
+	id, isChan := msg.Channel()
+	if !isChan {
+		if m, ok := msg.(*frame.OpenMessage); ok {
+			// the peer is reusing the ID of a closed channel
+			delete(rp.local, m.SenderID)
+			delete(rp.refused, m.SenderID)
+		}
+		return rec.Frame, nil
+	}
+	if rp.own[id] {
+		return nil, nil
+	}
+	peerID, ok := rp.peer[id]
+	if !ok {
+		return rec.Frame, nil
+	}
+	for {
+		if localID, ok := rp.local[peerID]; ok {
+			b := append([]byte(nil), rec.Frame...)
+			binary.BigEndian.PutUint32(b[1:5], localID)
+			return b, nil
+		}
+		if rp.refused[peerID] {
+			return nil, nil
+		}
+		if rp.closed {
+			return nil, net.ErrClosed
+		}
+		rp.cond.Wait()
+	}
+}
+
+// readWrites decodes the frames written by the replaying session to
+// learn the IDs it gives the channels the peer opens.
+func (rp *replay) readWrites(r *io.PipeReader) {
+	dec := frame.NewDecoder(r)
+	dec.MaxDataLength = math.MaxUint32
+	for {
+		msg, err := dec.Decode()
+		if err != nil {
+			r.CloseWithError(err)
+			return
+		}
+		rp.mu.Lock()
+		switch m := msg.(type) {
+		case *frame.OpenConfirmMessage:
+			rp.local[m.ChannelID] = m.SenderID
+		case *frame.OpenFailureMessage:
+			rp.refused[m.ChannelID] = true
+		}
+		rp.mu.Unlock()
+		rp.cond.Broadcast()
+	}
+}
+
+func (rp *replay) Write(p []byte) (int, error) {
+	n, err := rp.pw.Write(p)
+	if err == io.ErrClosedPipe {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+func (rp *replay) Close() error {
+	rp.mu.Lock()
+	rp.closed = true
+	rp.mu.Unlock()
+	rp.cond.Broadcast()
+	return rp.pw.Close()
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/progrium/qtalk-go/mux/frame"
 )
@@ -29,9 +31,32 @@ type Channel interface {
 	CloseWrite() error
 }
 
+// A Deadliner is a Channel that supports read and write deadlines
+// with the same semantics as net.Conn.
+type Deadliner interface {
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// A CloseNotifier is a Channel that can notify when it has been closed,
+// either by the other end or because the session ended.
+type CloseNotifier interface {
+	// CloseNotify returns a channel that is closed when the Channel is closed.
+	CloseNotify() <-chan struct{}
+}
+
 // channel is an implementation of the Channel interface that works
 // with the session class.
 type channel struct {
+	// bytesSent and bytesReceived count data bytes. They are accessed
+	// atomically and kept first for 64-bit alignment.
+	bytesSent     uint64
+	bytesReceived uint64
+
+	// observed is accessed atomically and tracks whether the observer
+	// has been told the channel opened (1) and then closed (2).
+	observed int32
 
 	// R/O after creation
 	localId, remoteId uint32
@@ -45,6 +70,11 @@ type channel struct {
 
 	session *session
 
+	// chanType and extra are the channel type and extra data
+	// sent with the open, if any.
+	chanType string
+	extra    []byte
+
 	// direction contains either channelOutbound, for channels created
 	// locally, or channelInbound, for channels created by the peer.
 	direction channelDirection
@@ -52,15 +82,28 @@ type channel struct {
 	// Pending internal channel messages.
 	msg chan frame.Message
 
+	// done is closed when the channel is closed.
+	done chan struct{}
+
 	sentEOF bool
 
 	// thread-safe data
 	remoteWin window
 	pending   *buffer
 
-	// windowMu protects myWindow, the flow-control window.
+	// windowMu protects myWindow, the flow-control window, and the
+	// fields below it used to batch and auto-tune window adjusts.
 	windowMu sync.Mutex
 	myWindow uint32
+	// winSize is the size of the window, which auto-tuning can grow.
+	winSize uint32
+	// consumed is how much has been read since the last window adjust.
+	consumed uint32
+	// epoch is when the last window adjust was sent.
+	epoch time.Time
+	// windowReleased is set once winSize is no longer counted in the
+	// session window total.
+	windowReleased bool
 
 	// writeMu serializes calls to session.conn.Write() and
 	// protects sentClose and packetPool. This mutex must be
@@ -71,14 +114,70 @@ type channel struct {
 
 	// packet buffer for writing
 	packetBuf []byte
+
+	// priority weights the channel's data writes, accessed atomically.
+	// queue is protected by the session scheduler's mutex.
+	priority int32
+	queue    writeQueue
+
+	// acceptState tracks whether an incoming channel waiting in the accept
+	// backlog has been accepted or refused. It is accessed atomically.
+	acceptState int32
+	acceptTimer *time.Timer
+
+	// openState tracks whether the other end responded to the open of an
+	// outgoing channel before Open gave up waiting. It is accessed
+	// atomically.
+	openState int32
 }
 
+// States of an incoming channel waiting to be accepted.
+const (
+	acceptWaiting int32 = iota
+	acceptTaken
+	acceptRefused
+)
+
+// States of an outgoing channel waiting for the other end to respond.
+const (
+	openWaiting int32 = iota
+	openResponded
+	openAbandoned
+)
+
 // ID returns the unique identifier of this channel
 // within the session
 func (ch *channel) ID() uint32 {
 	return ch.localId
 }
 
+// CloseNotify returns a channel that is closed when the channel is closed.
+func (ch *channel) CloseNotify() <-chan struct{} {
+	return ch.done
+}
+
+// SetDeadline sets the read and write deadlines of the channel.
+func (ch *channel) SetDeadline(t time.Time) error {
+	ch.SetReadDeadline(t)
+	return ch.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for blocked and future Read calls.
+// Reads that time out return os.ErrDeadlineExceeded. A zero value for
+// t means Read will not time out.
+func (ch *channel) SetReadDeadline(t time.Time) error {
+	ch.pending.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write calls blocked on the
+// flow-control window. Writes that time out return os.ErrDeadlineExceeded.
+// A zero value for t means Write will not time out.
+func (ch *channel) SetWriteDeadline(t time.Time) error {
+	ch.remoteWin.setDeadline(t)
+	return nil
+}
+
 // CloseWrite signals the end of sending data.
 // The other side may still send data
 func (ch *channel) CloseWrite() error {
@@ -102,19 +201,23 @@ func (ch *channel) Write(data []byte) (n int, err error) {
 
 	for len(data) > 0 {
 		space := min(ch.maxRemotePayload, len(data))
+		if space > ch.session.cfg.WriteChunkSize {
+			space = ch.session.cfg.WriteChunkSize
+		}
 		if space, err = ch.remoteWin.reserve(space); err != nil {
 			return n, err
 		}
 
 		toSend := data[:space]
 
-		if err = ch.session.enc.Encode(frame.DataMessage{
+		if err = ch.session.encodeOn(ch, frame.DataMessage{
 			ChannelID: ch.remoteId,
 			Length:    uint32(len(toSend)),
 			Data:      toSend,
 		}); err != nil {
 			return n, err
 		}
+		atomic.AddUint64(&ch.bytesSent, uint64(len(toSend)))
 
 		n += len(toSend)
 		data = data[len(toSend):]
@@ -150,28 +253,48 @@ func (ch *channel) send(msg frame.Message) error {
 		return io.EOF
 	}
 
-	if _, ok := msg.(frame.CloseMessage); ok {
+	switch msg.(type) {
+	case frame.CloseMessage:
 		ch.sentClose = true
+	case frame.WindowAdjustMessage:
+		return ch.session.encode(msg)
 	}
 
-	return ch.session.enc.Encode(msg)
+	// closes and EOFs must not overtake data still waiting to be written
+	return ch.session.encodeOn(ch, msg)
 }
 
 func (c *channel) adjustWindow(n uint32) error {
 	c.windowMu.Lock()
+	// Window adjusts are batched until half the window has been read,
+	// rather than sent for every read.
+	c.consumed += n
+	if c.consumed < c.winSize/2 {
+		c.windowMu.Unlock()
+		return nil
+	}
+	adj := c.consumed + c.session.growWindow(c)
+	c.consumed = 0
 	// Since myWindow is managed on our side, and can never exceed
-	// the initial window setting, we don't worry about overflow.
-	c.myWindow += uint32(n)
+	// the window size, we don't worry about overflow.
+	c.myWindow += adj
 	c.windowMu.Unlock()
 	return c.send(frame.WindowAdjustMessage{
 		ChannelID:       c.remoteId,
-		AdditionalBytes: uint32(n),
+		AdditionalBytes: adj,
 	})
 }
 
 func (c *channel) close() {
+	// keep a channel waiting in the accept backlog from being accepted
+	atomic.CompareAndSwapInt32(&c.acceptState, acceptWaiting, acceptRefused)
+	if c.direction == channelInbound {
+		atomic.AddInt32(&c.session.incoming, -1)
+	}
+	c.releaseWindow()
 	c.pending.eof()
 	close(c.msg)
+	close(c.done)
 	c.writeMu.Lock()
 	// This is not necessary for a normal channel teardown, but if
 	// there was another error, it is.
@@ -179,6 +302,22 @@ func (c *channel) close() {
 	c.writeMu.Unlock()
 	// Unblock writers.
 	c.remoteWin.close()
+
+	c.remoteWin.L.Lock()
+	c.session.stats.addBlocked(c.remoteWin.blocked)
+	c.remoteWin.L.Unlock()
+
+	if atomic.SwapInt32(&c.observed, 2) == 1 {
+		c.session.cfg.Observer.ChannelClose(c.localId)
+	}
+}
+
+// observeOpen notifies the observer that the channel opened,
+// unless it has already been closed.
+func (c *channel) observeOpen() {
+	if atomic.CompareAndSwapInt32(&c.observed, 0, 1) {
+		c.session.cfg.Observer.ChannelOpen(c.localId, c.direction == channelInbound)
+	}
 }
 
 // responseMessageReceived is called when a success or failure message is
@@ -208,7 +347,14 @@ func (ch *channel) handle(msg frame.Message) error {
 		ch.pending.eof()
 		return nil
 
+	case *frame.ResetMessage:
+		ch.handleReset(m)
+		return nil
+
 	case *frame.WindowAdjustMessage:
+		if m.AdditionalBytes == 0 {
+			ch.session.setPeerExtended()
+		}
 		if !ch.remoteWin.add(m.AdditionalBytes) {
 			return fmt.Errorf("qmux: invalid window update for %d bytes", m.AdditionalBytes)
 		}
@@ -224,6 +370,14 @@ func (ch *channel) handle(msg frame.Message) error {
 		ch.remoteId = m.SenderID
 		ch.maxRemotePayload = m.MaxPacketSize
 		ch.remoteWin.add(m.WindowSize)
+		if err := ch.session.announce(ch.remoteId); err != nil {
+			return err
+		}
+		if !atomic.CompareAndSwapInt32(&ch.openState, openWaiting, openResponded) {
+			// Open gave up waiting, so nothing will use the channel
+			ch.Close()
+			return nil
+		}
 		ch.msg <- m
 		return nil
 
@@ -231,7 +385,9 @@ func (ch *channel) handle(msg frame.Message) error {
 		if err := ch.responseMessageReceived(); err != nil {
 			return err
 		}
+		atomic.CompareAndSwapInt32(&ch.openState, openWaiting, openResponded)
 		ch.session.chans.remove(m.ChannelID)
+		ch.releaseWindow()
 		ch.msg <- m
 		return nil
 
@@ -259,6 +415,7 @@ func (ch *channel) handleData(msg *frame.DataMessage) error {
 	ch.myWindow -= msg.Length
 	ch.windowMu.Unlock()
 
-	ch.pending.write(msg.Data)
+	atomic.AddUint64(&ch.bytesReceived, uint64(msg.Length))
+	ch.pending.write(msg.Data, ch.session.payload)
 	return nil
 }
@@ -0,0 +1,37 @@
+package mux
+
+import (
+	"sync/atomic"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+// Peers using the original qmux protocol fail the whole session on any
+// frame type added since, such as GoAway. Those frames are only sent once
+// the other end is known to support them, which each end tells the other
+// with a zero sized window adjust on the first channel it sets up. The
+// original protocol never sends one and ignores it as a noop.
+
+// announce tells the other end that the added frame types are supported,
+// with a window adjust on the channel it knows as remoteId, unless this
+// has already been done.
+func (s *session) announce(remoteId uint32) error {
+	if !atomic.CompareAndSwapInt32(&s.announced, 0, 1) {
+		return nil
+	}
+	return s.encode(frame.WindowAdjustMessage{
+		ChannelID: remoteId,
+	})
+}
+
+// peerExtended reports whether the other end is known to support
+// the frame types added to the original protocol.
+func (s *session) peerExtended() bool {
+	return atomic.LoadInt32(&s.extended) == 1
+}
+
+// setPeerExtended records that the other end supports the frame types
+// added to the original protocol.
+func (s *session) setPeerExtended() {
+	atomic.StoreInt32(&s.extended, 1)
+}
@@ -0,0 +1,234 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+// Message numbers of the original qmux protocol.
+const (
+	baselineOpen = iota + 100
+	baselineOpenConfirm
+	baselineOpenFailure
+	baselineWindowAdjust
+	baselineData
+	baselineEOF
+	baselineClose
+)
+
+// baselineDecoder decodes frames the way peers using the original qmux
+// protocol do, failing on any frame type added since.
+type baselineDecoder struct {
+	r io.Reader
+}
+
+func (d baselineDecoder) Decode() (frame.Message, error) {
+	var num [1]byte
+	if _, err := io.ReadFull(d.r, num[:]); err != nil {
+		return nil, err
+	}
+	var fields []uint32
+	switch num[0] {
+	case baselineOpen, baselineOpenConfirm:
+		fields = make([]uint32, 4)
+		if num[0] == baselineOpen {
+			fields = fields[:3]
+		}
+	case baselineWindowAdjust, baselineData:
+		fields = make([]uint32, 2)
+	case baselineOpenFailure, baselineEOF, baselineClose:
+		fields = make([]uint32, 1)
+	default:
+		return nil, fmt.Errorf("unexpected message type %d", num[0])
+	}
+	if err := binary.Read(d.r, binary.BigEndian, fields); err != nil {
+		return nil, err
+	}
+	switch num[0] {
+	case baselineOpen:
+		return &frame.OpenMessage{SenderID: fields[0], WindowSize: fields[1], MaxPacketSize: fields[2]}, nil
+	case baselineOpenConfirm:
+		return &frame.OpenConfirmMessage{ChannelID: fields[0], SenderID: fields[1], WindowSize: fields[2], MaxPacketSize: fields[3]}, nil
+	case baselineOpenFailure:
+		return &frame.OpenFailureMessage{ChannelID: fields[0]}, nil
+	case baselineWindowAdjust:
+		return &frame.WindowAdjustMessage{ChannelID: fields[0], AdditionalBytes: fields[1]}, nil
+	case baselineData:
+		data := make([]byte, fields[1])
+		if _, err := io.ReadFull(d.r, data); err != nil {
+			return nil, err
+		}
+		return &frame.DataMessage{ChannelID: fields[0], Length: fields[1], Data: data}, nil
+	case baselineEOF:
+		return &frame.EOFMessage{ChannelID: fields[0]}, nil
+	default:
+		return &frame.CloseMessage{ChannelID: fields[0]}, nil
+	}
+}
+
+// baselinePeer is the other end of a session that speaks the original
+// qmux protocol. Frames the session sends are decoded as the original
+// protocol would, and passed on to frames until it fails.
+type baselinePeer struct {
+	enc    *frame.Encoder
+	frames chan frame.Message
+	err    chan error
+}
+
+func newBaselinePeer(t *testing.T, cfg Config) (Session, *baselinePeer) {
+	t.Helper()
+	a, b := net.Pipe()
+	sess := NewWithConfig(a, cfg)
+	t.Cleanup(func() {
+		sess.Close()
+		b.Close()
+	})
+	p := &baselinePeer{
+		enc:    frame.NewEncoder(b),
+		frames: make(chan frame.Message, 16),
+		err:    make(chan error, 1),
+	}
+	go func() {
+		dec := baselineDecoder{r: b}
+		for {
+			msg, err := dec.Decode()
+			if err != nil {
+				p.err <- err
+				close(p.frames)
+				return
+			}
+			p.frames <- msg
+		}
+	}()
+	return sess, p
+}
+
+// next returns the next frame the session sent, failing the test if it
+// can't be decoded by the original protocol.
+func (p *baselinePeer) next(t *testing.T) frame.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-p.frames:
+		if !ok {
+			t.Fatalf("peer failed to decode frame: %v", <-p.err)
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a frame")
+		return nil
+	}
+}
+
+// open opens a channel from the peer, returning the session end of it.
+func (p *baselinePeer) open(t *testing.T, sess Session) Channel {
+	t.Helper()
+	fatal(p.enc.Encode(frame.OpenMessage{SenderID: 7, WindowSize: 1 << 16, MaxPacketSize: 1 << 16}), t)
+	ch, err := sess.Accept()
+	fatal(err, t)
+	for {
+		if _, ok := p.next(t).(*frame.OpenConfirmMessage); ok {
+			return ch
+		}
+	}
+}
+
+// waitExtended waits until sess knows the other end supports the frame
+// types added to the original protocol.
+func waitExtended(t *testing.T, sess Session) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !sess.(*session).peerExtended() {
+		if time.Now().After(deadline) {
+			t.Fatal("session did not learn that the other end is extended")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// negotiate sets up a channel from sessA to sessB, so each learns that
+// the other supports the frame types added to the original protocol.
+func negotiate(t *testing.T, sessA, sessB Session) {
+	t.Helper()
+	chA, _ := openPair(t, sessA, sessB)
+	waitExtended(t, sessA)
+	waitExtended(t, sessB)
+	fatal(chA.Close(), t)
+}
+
+func TestCompatNegotiation(t *testing.T) {
+	sessA, sessB := newPipeSessions(t)
+	negotiate(t, sessA, sessB)
+
+	sess, peer := newBaselinePeer(t, Config{})
+	peer.open(t, sess)
+	if sess.(*session).peerExtended() {
+		t.Fatal("session took a peer using the original protocol for extended")
+	}
+}
+
+func TestCompatShutdown(t *testing.T) {
+	sess, peer := newBaselinePeer(t, Config{})
+	ch := peer.open(t, sess)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- sess.(Shutdowner).Shutdown(context.Background())
+	}()
+	fatal(peer.enc.Encode(frame.CloseMessage{ChannelID: ch.(*channel).localId}), t)
+	if msg, ok := peer.next(t).(*frame.CloseMessage); !ok || msg.ChannelID != 7 {
+		t.Fatalf("unexpected frame: %v", msg)
+	}
+
+	select {
+	case err := <-shutdownErr:
+		fatal(err, t)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not finish after channels closed")
+	}
+}
+
+func TestCompatOpenFailure(t *testing.T) {
+	sess, peer := newBaselinePeer(t, Config{
+		AcceptFilter: func(req OpenRequest) error {
+			return &OpenError{Reason: ResourceShortage, Message: "no room"}
+		},
+	})
+	fatal(peer.enc.Encode(frame.OpenMessage{SenderID: 7, WindowSize: 1 << 16, MaxPacketSize: 1 << 16}), t)
+	if msg, ok := peer.next(t).(*frame.OpenFailureMessage); !ok || msg.ChannelID != 7 {
+		t.Fatalf("unexpected frame: %v", msg)
+	}
+
+	// the session keeps working for the peer
+	fatal(peer.enc.Encode(frame.OpenMessage{SenderID: 8, WindowSize: 1 << 16, MaxPacketSize: 1 << 16}), t)
+	if msg, ok := peer.next(t).(*frame.OpenFailureMessage); !ok || msg.ChannelID != 8 {
+		t.Fatalf("unexpected frame: %v", msg)
+	}
+	select {
+	case <-sess.(*session).done:
+		t.Fatal("session ended")
+	default:
+	}
+}
+
+func TestCompatReset(t *testing.T) {
+	sess, peer := newBaselinePeer(t, Config{})
+	ch := peer.open(t, sess)
+
+	fatal(ch.(Resetter).Reset(1), t)
+	if msg, ok := peer.next(t).(*frame.CloseMessage); !ok || msg.ChannelID != 7 {
+		t.Fatalf("unexpected frame: %v", msg)
+	}
+	_, err := ch.Read(make([]byte, 1))
+	var reset *ResetError
+	if !errors.As(err, &reset) || reset.Code != 1 || reset.Remote {
+		t.Fatalf("expected a local ResetError, but got: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// errNoDeadline is returned by Conn deadline methods when the
+// underlying channel does not implement Deadliner.
+var errNoDeadline = errors.New("qmux: channel does not support deadlines")
+
+// Conn returns a net.Conn that reads and writes over ch, so channels can be
+// used with code like http.Server or tls.Client. The local and remote
+// addresses are synthetic and identify the channel within its session.
+// Deadlines are supported if ch implements Deadliner.
+func Conn(ch Channel) net.Conn {
+	return &conn{Channel: ch}
+}
+
+type conn struct {
+	Channel
+}
+
+// chanAddr is the synthetic net.Addr of one end of a channel.
+type chanAddr uint32
+
+func (a chanAddr) Network() string {
+	return "qmux"
+}
+
+func (a chanAddr) String() string {
+	return fmt.Sprintf("qmux:%d", uint32(a))
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return chanAddr(c.Channel.ID())
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	if ch, ok := c.Channel.(*channel); ok {
+		return chanAddr(ch.remoteId)
+	}
+	return chanAddr(c.Channel.ID())
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	if d, ok := c.Channel.(Deadliner); ok {
+		return d.SetDeadline(t)
+	}
+	return errNoDeadline
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.Channel.(Deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return errNoDeadline
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.Channel.(Deadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return errNoDeadline
+}
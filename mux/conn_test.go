@@ -0,0 +1,187 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+func newPipeSessions(t *testing.T) (Session, Session) {
+	t.Helper()
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := New(b)
+	t.Cleanup(func() {
+		sessA.Close()
+		sessB.Close()
+	})
+	return sessA, sessB
+}
+
+func openPair(t *testing.T, sessA, sessB Session) (Channel, Channel) {
+	t.Helper()
+	accepted := make(chan Channel, 1)
+	go func() {
+		ch, err := sessB.Accept()
+		fatal(err, t)
+		accepted <- ch
+	}()
+	chA, err := sessA.Open(context.Background())
+	fatal(err, t)
+	return chA, <-accepted
+}
+
+func TestChannelReadDeadline(t *testing.T) {
+	sessA, sessB := newPipeSessions(t)
+	chA, chB := openPair(t, sessA, sessB)
+
+	fatal(chB.(Deadliner).SetReadDeadline(time.Now().Add(20*time.Millisecond)), t)
+	_, err := chB.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected ErrDeadlineExceeded, but got: %v", err)
+	}
+
+	// clearing the deadline makes reads block for data again
+	fatal(chB.(Deadliner).SetReadDeadline(time.Time{}), t)
+	_, err = chA.Write([]byte("x"))
+	fatal(err, t)
+	n, err := chB.Read(make([]byte, 1))
+	fatal(err, t)
+	if n != 1 {
+		t.Fatalf("unexpected read length: %d", n)
+	}
+}
+
+func TestChannelReadDeadlinePartialFrame(t *testing.T) {
+	a, b := net.Pipe()
+	sess := New(a)
+	defer sess.Close()
+	defer b.Close()
+	go io.Copy(ioutil.Discard, b)
+
+	// the other end sends only part of a data frame and then stalls
+	enc := frame.NewEncoder(b)
+	fatal(enc.Encode(frame.OpenMessage{SenderID: 3, WindowSize: 1 << 16, MaxPacketSize: 1 << 16}), t)
+	ch, err := sess.Accept()
+	fatal(err, t)
+	fatal(ch.(Deadliner).SetReadDeadline(time.Now().Add(50*time.Millisecond)), t)
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := ch.Read(make([]byte, 1024))
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond) // let the read start waiting
+	var buf bytes.Buffer
+	fatal(frame.NewEncoder(&buf).Encode(frame.DataMessage{ChannelID: 0, Length: 1024, Data: make([]byte, 1024)}), t)
+	_, err = b.Write(buf.Bytes()[:100])
+	fatal(err, t)
+
+	select {
+	case err := <-readErr:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("expected ErrDeadlineExceeded, but got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read waited on a partial frame past its deadline")
+	}
+}
+
+func TestChannelWriteDeadline(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := NewWithConfig(b, Config{
+		MaxPacketSize: 1024,
+		WindowSize:    1024,
+	})
+	defer sessA.Close()
+	defer sessB.Close()
+	chA, _ := openPair(t, sessA, sessB)
+
+	fatal(chA.(Deadliner).SetWriteDeadline(time.Now().Add(20*time.Millisecond)), t)
+	// the window is used up by the first 1024 bytes and never adjusted
+	// since the other end doesn't read
+	n, err := chA.Write(make([]byte, 2048))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected ErrDeadlineExceeded, but got: %v", err)
+	}
+	if n != 1024 {
+		t.Fatalf("unexpected write length: %d", n)
+	}
+}
+
+// chanListener is a net.Listener accepting channels from a session.
+type chanListener struct {
+	Session
+}
+
+func (l chanListener) Accept() (net.Conn, error) {
+	ch, err := l.Session.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Conn(ch), nil
+}
+
+func (l chanListener) Addr() net.Addr {
+	return chanAddr(0)
+}
+
+func TestConnHTTP(t *testing.T) {
+	sessA, sessB := newPipeSessions(t)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Hello " + r.RemoteAddr))
+		}),
+	}
+	go srv.Serve(chanListener{sessB})
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				ch, err := sessA.Open(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return Conn(ch), nil
+			},
+		},
+	}
+	resp, err := client.Get("http://qmux/")
+	fatal(err, t)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	fatal(err, t)
+	if string(b) != "Hello qmux:0" {
+		t.Fatalf("unexpected body: %s", b)
+	}
+}
+
+func TestChannelCloseNotify(t *testing.T) {
+	sessA, sessB := newPipeSessions(t)
+	chA, chB := openPair(t, sessA, sessB)
+
+	done := chB.(CloseNotifier).CloseNotify()
+	select {
+	case <-done:
+		t.Fatal("channel closed before Close")
+	default:
+	}
+
+	fatal(chA.Close(), t)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for close notification")
+	}
+}
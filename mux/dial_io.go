@@ -7,7 +7,13 @@ import (
 
 // DialIO establishes a mux session using a WriterCloser and ReadCloser.
 func DialIO(out io.WriteCloser, in io.ReadCloser) (Session, error) {
-	return New(&ioduplex{out, in}), nil
+	return DialIOWithConfig(out, in, Config{})
+}
+
+// DialIOWithConfig establishes a mux session using a WriterCloser and
+// ReadCloser with the session settings in cfg.
+func DialIOWithConfig(out io.WriteCloser, in io.ReadCloser, cfg Config) (Session, error) {
+	return NewWithConfig(&ioduplex{out, in}, cfg), nil
 }
 
 // DialIO establishes a mux session using Stdout and Stdin.
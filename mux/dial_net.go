@@ -1,23 +1,47 @@
 package mux
 
 import (
+	"crypto/tls"
 	"net"
 )
 
-func dialNet(proto, addr string) (Session, error) {
+func dialNet(proto, addr string, cfg Config) (Session, error) {
 	conn, err := net.Dial(proto, addr)
 	if err != nil {
 		return nil, err
 	}
-	return New(conn), nil
+	return NewWithConfig(conn, cfg), nil
 }
 
 // DialTCP establishes a mux session via TCP connection.
 func DialTCP(addr string) (Session, error) {
-	return dialNet("tcp", addr)
+	return dialNet("tcp", addr, Config{})
+}
+
+// DialTCPWithConfig establishes a mux session via TCP connection
+// using the session settings in cfg.
+func DialTCPWithConfig(addr string, cfg Config) (Session, error) {
+	return dialNet("tcp", addr, cfg)
 }
 
 // DialUnix establishes a mux session via Unix domain socket.
 func DialUnix(path string) (Session, error) {
-	return dialNet("unix", path)
+	return dialNet("unix", path, Config{})
+}
+
+// DialTLS establishes a mux session via TLS connection. If config is nil,
+// the default configuration is used. For mutual TLS, config should have
+// the client certificate.
+func DialTLS(addr string, config *tls.Config) (Session, error) {
+	return DialTLSWithConfig(addr, config, Config{})
+}
+
+// DialTLSWithConfig establishes a mux session via TLS connection
+// using the session settings in cfg.
+func DialTLSWithConfig(addr string, config *tls.Config, cfg Config) (Session, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithConfig(conn, cfg), nil
 }
@@ -1,6 +1,7 @@
 package mux
 
 import (
+	"crypto/tls"
 	"fmt"
 
 	"golang.org/x/net/websocket"
@@ -10,10 +11,46 @@ import (
 // The address must be a host and port. Opening a WebSocket
 // connection at a particular path is not supported.
 func DialWS(addr string) (Session, error) {
+	return DialWSWithConfig(addr, Config{})
+}
+
+// DialWSWithConfig establishes a mux session via WebSocket connection
+// using the session settings in cfg.
+func DialWSWithConfig(addr string, cfg Config) (Session, error) {
 	ws, err := websocket.Dial(fmt.Sprintf("ws://%s/", addr), "", fmt.Sprintf("http://%s/", addr))
 	if err != nil {
 		return nil, err
 	}
 	ws.PayloadType = websocket.BinaryFrame
-	return New(ws), nil
+	return NewWithConfig(ws, cfg), nil
+}
+
+// DialWSS establishes a mux session via WebSocket connection over TLS.
+// If config is nil, the default configuration is used. As with DialWS,
+// the address must be a host and port.
+func DialWSS(addr string, config *tls.Config) (Session, error) {
+	return DialWSSWithConfig(addr, config, Config{})
+}
+
+// DialWSSWithConfig establishes a mux session via WebSocket connection
+// over TLS using the session settings in cfg.
+func DialWSSWithConfig(addr string, config *tls.Config, cfg Config) (Session, error) {
+	wsConfig, err := websocket.NewConfig(fmt.Sprintf("wss://%s/", addr), fmt.Sprintf("https://%s/", addr))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.NewClient(wsConfig, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return NewWithConfig(&tlsTransport{
+		ReadWriteCloser: ws,
+		state:           conn.ConnectionState,
+	}, cfg), nil
 }
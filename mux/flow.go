@@ -0,0 +1,101 @@
+package mux
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// rtt returns the smoothed round-trip time measured by pings,
+// or zero if none has been measured yet.
+func (s *session) rtt() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.srtt))
+}
+
+// updateRTT adds a round-trip time sample to the smoothed estimate.
+func (s *session) updateRTT(sample time.Duration) {
+	for {
+		old := atomic.LoadInt64(&s.srtt)
+		srtt := int64(sample)
+		if old != 0 {
+			// same smoothing factor as TCP (RFC 6298)
+			srtt = old - old/8 + int64(sample)/8
+		}
+		if atomic.CompareAndSwapInt64(&s.srtt, old, srtt) {
+			return
+		}
+	}
+}
+
+// autoTune reports whether window auto-tuning is enabled.
+func (s *session) autoTune() bool {
+	return s.cfg.MaxWindowSize > s.cfg.WindowSize
+}
+
+// measureRTT sends a ping to get the first round-trip time sample used
+// for auto-tuning. Later samples come from keepalives and other pings.
+func (s *session) measureRTT() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.AcceptTimeout)
+	defer cancel()
+	s.Ping(ctx)
+}
+
+// growWindow returns how much to grow the window of c by. It is called
+// with c.windowMu held each time a window adjust is sent, which happens
+// after half the window has been read. If that took less than two round
+// trips, the other end is sending as fast as the window allows and is
+// likely waiting on window adjusts, so the window is doubled up to
+// MaxWindowSize while the session window budget allows.
+func (s *session) growWindow(c *channel) uint32 {
+	now := time.Now()
+	elapsed := now.Sub(c.epoch)
+	c.epoch = now
+
+	if !s.autoTune() || c.winSize >= s.cfg.MaxWindowSize {
+		return 0
+	}
+	rtt := s.rtt()
+	if rtt == 0 || elapsed >= 2*rtt {
+		return 0
+	}
+	grow := c.winSize
+	if grow > s.cfg.MaxWindowSize-c.winSize {
+		grow = s.cfg.MaxWindowSize - c.winSize
+	}
+	grow = uint32(s.reserveWindow(uint64(grow)))
+	c.winSize += grow
+	return grow
+}
+
+// reserveWindow adds up to n bytes of window to the session total,
+// without going over MaxSessionWindow, and returns how much it added.
+func (s *session) reserveWindow(n uint64) uint64 {
+	if s.cfg.MaxSessionWindow == 0 {
+		atomic.AddUint64(&s.windowTotal, n)
+		return n
+	}
+	for {
+		total := atomic.LoadUint64(&s.windowTotal)
+		if total >= s.cfg.MaxSessionWindow {
+			return 0
+		}
+		if avail := s.cfg.MaxSessionWindow - total; n > avail {
+			n = avail
+		}
+		if atomic.CompareAndSwapUint64(&s.windowTotal, total, total+n) {
+			return n
+		}
+	}
+}
+
+// releaseWindow removes the window of c from the session total
+// once c is no longer used.
+func (c *channel) releaseWindow() {
+	c.windowMu.Lock()
+	defer c.windowMu.Unlock()
+	if c.windowReleased {
+		return
+	}
+	c.windowReleased = true
+	atomic.AddUint64(&c.session.windowTotal, -uint64(c.winSize))
+}
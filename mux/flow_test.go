@@ -0,0 +1,316 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+// delayConn delays everything written to a net.Conn by a fixed
+// latency without limiting bandwidth.
+type delayConn struct {
+	net.Conn
+	latency time.Duration
+	queue   chan delayed
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type delayed struct {
+	at   time.Time
+	data []byte
+}
+
+func newDelayConn(conn net.Conn, latency time.Duration) *delayConn {
+	c := &delayConn{
+		Conn:    conn,
+		latency: latency,
+		queue:   make(chan delayed, 4096),
+	}
+	go func() {
+		for d := range c.queue {
+			time.Sleep(time.Until(d.at))
+			if _, err := c.Conn.Write(d.data); err != nil {
+				break
+			}
+		}
+		c.Conn.Close()
+	}()
+	return c
+}
+
+func (c *delayConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	c.queue <- delayed{at: time.Now().Add(c.latency), data: append([]byte(nil), p...)}
+	return len(p), nil
+}
+
+func (c *delayConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	return nil
+}
+
+// newDelaySessions returns sessions connected by a pipe with the given
+// latency in each direction.
+func newDelaySessions(t *testing.T, latency time.Duration, cfg Config) (Session, Session) {
+	t.Helper()
+	a, b := net.Pipe()
+	sessA := NewWithConfig(newDelayConn(a, latency), cfg)
+	sessB := NewWithConfig(newDelayConn(b, latency), cfg)
+	t.Cleanup(func() {
+		sessA.Close()
+		sessB.Close()
+	})
+	return sessA, sessB
+}
+
+// transfer writes n bytes from chA to chB and returns how long it took.
+func transfer(t *testing.T, chA, chB Channel, n int) time.Duration {
+	t.Helper()
+	data := bytes.Repeat([]byte{'x'}, n)
+	start := time.Now()
+	go func() {
+		_, err := chA.Write(data)
+		fatal(err, t)
+		fatal(chA.CloseWrite(), t)
+	}()
+	b, err := io.ReadAll(chB)
+	fatal(err, t)
+	if len(b) != n {
+		t.Fatalf("unexpected bytes read: %d", len(b))
+	}
+	return time.Since(start)
+}
+
+func TestWindowBatching(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := NewWithConfig(b, Config{WindowSize: 4096})
+	defer sessA.Close()
+	defer sessB.Close()
+	chA, chB := openPair(t, sessA, sessB)
+
+	go func() {
+		_, err := chA.Write(make([]byte, 64*1024))
+		fatal(err, t)
+		chA.Close()
+	}()
+	buf := make([]byte, 100)
+	var n int
+	for {
+		nn, err := chB.Read(buf)
+		n += nn
+		if err == io.EOF {
+			break
+		}
+		fatal(err, t)
+	}
+	if n != 64*1024 {
+		t.Fatalf("unexpected bytes read: %d", n)
+	}
+
+	// one adjust per half window read instead of one per read
+	adjusts := sessB.(StatsReporter).Stats().FramesSent["WindowAdjust"].Frames
+	if adjusts > 32 {
+		t.Fatalf("too many window adjusts sent: %d", adjusts)
+	}
+}
+
+func TestWindowAutoTune(t *testing.T) {
+	const latency = 5 * time.Millisecond
+	const size = 1 << 20
+
+	fixedA, fixedB := newDelaySessions(t, latency, Config{WindowSize: 16 << 10})
+	chA, chB := openPair(t, fixedA, fixedB)
+	fixed := transfer(t, chA, chB, size)
+
+	tunedA, tunedB := newDelaySessions(t, latency, Config{
+		WindowSize:    16 << 10,
+		MaxWindowSize: 1 << 20,
+	})
+	// wait for the round-trip time to be measured
+	_, err := tunedB.(Pinger).Ping(context.Background())
+	fatal(err, t)
+	chA, chB = openPair(t, tunedA, tunedB)
+	tuned := transfer(t, chA, chB, size)
+
+	win := chB.(ChannelStatsReporter).Stats().WindowSize
+	if win <= 16<<10 || win > 1<<20 {
+		t.Fatalf("unexpected tuned window size: %d", win)
+	}
+	if tuned >= fixed {
+		t.Fatalf("auto-tuned transfer took %s, fixed window took %s", tuned, fixed)
+	}
+}
+
+func TestSessionWindowLimit(t *testing.T) {
+	sessA, sessB := newDelaySessions(t, 5*time.Millisecond, Config{
+		WindowSize:       16 << 10,
+		MaxWindowSize:    1 << 20,
+		MaxSessionWindow: 64 << 10,
+	})
+	_, err := sessB.(Pinger).Ping(context.Background())
+	fatal(err, t)
+	chA, chB := openPair(t, sessA, sessB)
+	transfer(t, chA, chB, 1<<20)
+
+	if win := chB.(ChannelStatsReporter).Stats().WindowSize; win > 64<<10 {
+		t.Fatalf("window grew past the session limit: %d", win)
+	}
+}
+
+func TestSessionWindowReserve(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := NewWithConfig(b, Config{
+		WindowSize:       16 << 10,
+		MaxSessionWindow: 40 << 10,
+	})
+	defer sessA.Close()
+	defer sessB.Close()
+
+	// channels opened by either end take their window from the limit,
+	// with the last one getting what is left
+	_, chB := openPair(t, sessA, sessB)
+	openPair(t, sessB, sessA)
+	last, _ := openPair(t, sessB, sessA)
+	if win := last.(ChannelStatsReporter).Stats().WindowSize; win != 8<<10 {
+		t.Fatalf("unexpected window size: %d", win)
+	}
+
+	ctx := context.Background()
+	if _, err := sessB.Open(ctx); err != ErrWindowExhausted {
+		t.Fatalf("expected ErrWindowExhausted, but got: %v", err)
+	}
+	_, err := sessA.Open(ctx)
+	var openErr *OpenError
+	if !errors.As(err, &openErr) || openErr.Reason != ResourceShortage {
+		t.Fatalf("expected open to fail with ResourceShortage, but got: %v", err)
+	}
+
+	// closing a channel gives its window back
+	fatal(chB.Close(), t)
+	<-chB.(*channel).done
+	_, chB = openPair(t, sessA, sessB)
+	if win := chB.(ChannelStatsReporter).Stats().WindowSize; win != 16<<10 {
+		t.Fatalf("unexpected window size: %d", win)
+	}
+}
+
+func TestAcceptBacklog(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := NewWithConfig(b, Config{AcceptBacklog: 2})
+	defer sessA.Close()
+	defer sessB.Close()
+
+	// a channel that is already open keeps working while the
+	// backlog is full
+	chA, chB := openPair(t, sessA, sessB)
+
+	ctx := context.Background()
+	opened := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := sessA.Open(ctx)
+			opened <- err
+		}()
+	}
+	// wait for both opens to reach the backlog
+	for len(sessB.(*session).inbox) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := sessA.Open(ctx)
+	var openErr *OpenError
+	if !errors.As(err, &openErr) || openErr.Reason != ResourceShortage {
+		t.Fatalf("expected open to fail with ResourceShortage, but got: %v", err)
+	}
+
+	go func() {
+		_, err := chA.Write([]byte("hello"))
+		fatal(err, t)
+	}()
+	_, err = io.ReadFull(chB, make([]byte, 5))
+	fatal(err, t)
+
+	for i := 0; i < 2; i++ {
+		_, err := sessB.Accept()
+		fatal(err, t)
+		fatal(<-opened, t)
+	}
+}
+
+func TestAcceptBacklogConfirm(t *testing.T) {
+	sess, peer := newBaselinePeer(t, Config{AcceptTimeout: 50 * time.Millisecond})
+	expectNone := func() {
+		t.Helper()
+		select {
+		case msg := <-peer.frames:
+			t.Fatalf("unexpected frame: %v", msg)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	// an open waiting in the backlog is confirmed once it is accepted
+	fatal(peer.enc.Encode(frame.OpenMessage{SenderID: 7, WindowSize: 1 << 16, MaxPacketSize: 1 << 16}), t)
+	expectNone()
+	_, err := sess.Accept()
+	fatal(err, t)
+	for {
+		if msg, ok := peer.next(t).(*frame.OpenConfirmMessage); ok {
+			if msg.ChannelID != 7 {
+				t.Fatalf("unexpected confirm: %v", msg)
+			}
+			break
+		}
+	}
+
+	// and refused without a confirm if it isn't accepted in time
+	fatal(peer.enc.Encode(frame.OpenMessage{SenderID: 8, WindowSize: 1 << 16, MaxPacketSize: 1 << 16}), t)
+	if msg, ok := peer.next(t).(*frame.OpenFailureMessage); !ok || msg.ChannelID != 8 {
+		t.Fatalf("unexpected frame: %v", msg)
+	}
+}
+
+func TestMaxIncomingChannels(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := NewWithConfig(b, Config{MaxIncomingChannels: 2})
+	defer sessA.Close()
+	defer sessB.Close()
+
+	chA, _ := openPair(t, sessA, sessB)
+	openPair(t, sessA, sessB)
+
+	_, err := sessA.Open(context.Background())
+	var openErr *OpenError
+	if !errors.As(err, &openErr) || openErr.Reason != ResourceShortage {
+		t.Fatalf("expected open to fail with ResourceShortage, but got: %v", err)
+	}
+
+	// closing a channel makes room for another
+	fatal(chA.Close(), t)
+	for atomic.LoadInt32(&sessB.(*session).incoming) == 2 {
+		time.Sleep(time.Millisecond)
+	}
+	openPair(t, sessA, sessB)
+}
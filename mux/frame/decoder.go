@@ -10,9 +10,37 @@ import (
 	"syscall"
 )
 
+// variableMessage is implemented by messages with variable length
+// fields, which can't be decoded using binary.Read.
+type variableMessage interface {
+	decode(msgNum byte, r io.Reader) error
+}
+
+// DefaultMaxDataLength is the largest data payload a Decoder
+// accepts unless its MaxDataLength is set.
+const DefaultMaxDataLength = 1 << 24
+
+// ErrFrameTooLarge is returned by Decode for a data message with a
+// payload larger than the decoder accepts.
+var ErrFrameTooLarge = errors.New("qtalk: frame too large")
+
 // Decoder decodes messages given an io.Reader
 type Decoder struct {
 	r io.Reader
+
+	// MaxDataLength is the largest data payload Decode accepts. Larger
+	// data messages fail with ErrFrameTooLarge before the payload is read
+	// or allocated. It defaults to DefaultMaxDataLength if zero.
+	MaxDataLength uint32
+
+	// Alloc, if set, returns the buffer the payload of a data message
+	// for channel is read into, which must have a length of n. Returning
+	// nil has Decode allocate one.
+	Alloc func(channel uint32, n int) []byte
+
+	// scratch is reused to read the message number and data headers.
+	scratch [8]byte
+
 	sync.Mutex
 }
 
@@ -24,8 +52,7 @@ func (dec *Decoder) Decode() (Message, error) {
 	dec.Lock()
 	defer dec.Unlock()
 
-	var msgNum [1]byte
-	_, err := io.ReadFull(dec.r, msgNum[:])
+	_, err := io.ReadFull(dec.r, dec.scratch[:1])
 	if err != nil {
 		var syscallErr *os.SyscallError
 		if errors.As(err, &syscallErr) && syscallErr.Err == syscall.ECONNRESET {
@@ -34,26 +61,19 @@ func (dec *Decoder) Decode() (Message, error) {
 		return nil, err
 	}
 
+	msgNum := [1]byte{dec.scratch[0]}
 	var msg Message
 	msg, err = messageFrom(msgNum)
 	if err != nil {
 		return nil, err
 	}
 
-	if msgNum[0] == msgChannelData {
-		var data struct {
-			ChannelID uint32
-			Length    uint32
-		}
-		if err := binary.Read(dec.r, binary.BigEndian, &data); err != nil {
+	if dmsg, ok := msg.(*DataMessage); ok {
+		if err := dec.decodeData(dmsg); err != nil {
 			return nil, err
 		}
-		dataMsg := msg.(*DataMessage)
-		dataMsg.ChannelID = data.ChannelID
-		dataMsg.Length = data.Length
-		dataMsg.Data = make([]byte, data.Length)
-		_, err := io.ReadFull(dec.r, dataMsg.Data)
-		if err != nil {
+	} else if vmsg, ok := msg.(variableMessage); ok {
+		if err := vmsg.decode(msgNum[0], dec.r); err != nil {
 			return nil, err
 		}
 	} else {
@@ -71,13 +91,13 @@ func (dec *Decoder) Decode() (Message, error) {
 
 func messageFrom(num [1]byte) (Message, error) {
 	switch num[0] {
-	case msgChannelOpen:
+	case msgChannelOpen, msgChannelOpenTyped:
 		return new(OpenMessage), nil
 	case msgChannelData:
 		return new(DataMessage), nil
 	case msgChannelOpenConfirm:
 		return new(OpenConfirmMessage), nil
-	case msgChannelOpenFailure:
+	case msgChannelOpenFailure, msgChannelOpenFailureReason:
 		return new(OpenFailureMessage), nil
 	case msgChannelWindowAdjust:
 		return new(WindowAdjustMessage), nil
@@ -85,6 +105,20 @@ func messageFrom(num [1]byte) (Message, error) {
 		return new(EOFMessage), nil
 	case msgChannelClose:
 		return new(CloseMessage), nil
+	case msgChannelReset:
+		return new(ResetMessage), nil
+	case msgPing:
+		return new(PingMessage), nil
+	case msgPong:
+		return new(PongMessage), nil
+	case msgGoAway:
+		return new(GoAwayMessage), nil
+	case msgGlobalRequest:
+		return new(GlobalRequestMessage), nil
+	case msgRequestSuccess:
+		return new(RequestSuccessMessage), nil
+	case msgRequestFailure:
+		return new(RequestFailureMessage), nil
 	default:
 		return nil, fmt.Errorf("qtalk: unexpected message type %d", num[0])
 	}
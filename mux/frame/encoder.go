@@ -1,19 +1,74 @@
 package frame
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"sync"
+	"time"
 )
 
+// vectorThreshold is the payload size above which data messages are
+// written with a vectored write rather than copied behind their header,
+// on writers that support vectored writes.
+const vectorThreshold = 4 << 10
+
+// maxRetainedBuffer is the largest write buffer an Encoder keeps
+// between frames.
+const maxRetainedBuffer = 64 << 10
+
 // Encoder encodes messages given an io.Writer
 type Encoder struct {
 	w io.Writer
 	sync.Mutex
+
+	// vectored is set if w writes net.Buffers in a single system call.
+	vectored bool
+	// buf is reused to write each frame, and iov and vec to write
+	// the header and payload of large data messages.
+	buf []byte
+	iov [2][]byte
+	vec net.Buffers
+
+	// limit is set for encoders from NewBatchEncoder, which gather frames
+	// in batch until delay has passed or limit bytes are waiting.
+	delay time.Duration
+	limit int
+	batch []byte
+	timer *time.Timer
+	armed bool
+	// err is the error writing a batch, returned by later calls.
+	err error
 }
 
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w}
+	return &Encoder{w: w, vectored: isVectored(w)}
+}
+
+// NewBatchEncoder returns an Encoder that gathers frames into batches, each
+// written to w in one call, to save on writes when many small frames are
+// sent. A batch is written once delay has passed since its first frame, once
+// adding a frame would take it past limit bytes, or when Flush is called.
+// Window adjusts, pings, pongs and resets are latency sensitive, so they are
+// written right away along with the frames batched before them.
+//
+// Encode returns once the frame is batched, so an error writing a batch is
+// returned by the next call to Encode or Flush.
+func NewBatchEncoder(w io.Writer, delay time.Duration, limit int) *Encoder {
+	enc := NewEncoder(w)
+	enc.delay = delay
+	enc.limit = limit
+	return enc
+}
+
+// isVectored reports whether w supports vectored writes of net.Buffers.
+func isVectored(w io.Writer) bool {
+	switch w.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return true
+	}
+	return false
 }
 
 func (enc *Encoder) Encode(msg Message) error {
@@ -24,6 +79,120 @@ func (enc *Encoder) Encode(msg Message) error {
 		fmt.Fprintln(Debug, "<<ENC", msg)
 	}
 
-	_, err := enc.w.Write(msg.Bytes())
+	if enc.limit > 0 {
+		return enc.encodeBatched(msg)
+	}
+	return enc.write(msg)
+}
+
+// Flush writes the frames waiting to be batched, if any.
+func (enc *Encoder) Flush() error {
+	enc.Lock()
+	defer enc.Unlock()
+	return enc.flush()
+}
+
+// write writes a frame on its own.
+func (enc *Encoder) write(msg Message) error {
+	data, ok := msg.(DataMessage)
+	if !ok {
+		_, err := enc.w.Write(msg.Bytes())
+		return err
+	}
+
+	// data messages are written without allocating, reusing buf for the
+	// header and, unless it is large enough to write separately, the payload
+	enc.buf = appendDataHeader(enc.buf[:0], data)
+	if enc.vectored && len(data.Data) > vectorThreshold {
+		enc.iov[0], enc.iov[1] = enc.buf, data.Data
+		enc.vec = enc.iov[:]
+		_, err := enc.vec.WriteTo(enc.w)
+		enc.iov[1] = nil
+		return err
+	}
+	enc.buf = append(enc.buf, data.Data...)
+	_, err := enc.w.Write(enc.buf)
+	if cap(enc.buf) > maxRetainedBuffer {
+		enc.buf = nil
+	}
 	return err
 }
+
+// encodeBatched adds a frame to the batch.
+func (enc *Encoder) encodeBatched(msg Message) error {
+	if enc.err != nil {
+		return enc.err
+	}
+
+	var b []byte
+	data, isData := msg.(DataMessage)
+	size := 9 + len(data.Data)
+	if !isData {
+		b = msg.Bytes()
+		size = len(b)
+	}
+	if len(enc.batch)+size > enc.limit {
+		if err := enc.flush(); err != nil {
+			return err
+		}
+	}
+	if size > enc.limit {
+		// too large to batch
+		if err := enc.write(msg); err != nil {
+			enc.err = err
+			return err
+		}
+		return nil
+	}
+
+	if isData {
+		enc.batch = appendDataHeader(enc.batch, data)
+		enc.batch = append(enc.batch, data.Data...)
+	} else {
+		enc.batch = append(enc.batch, b...)
+	}
+
+	switch msg.(type) {
+	case WindowAdjustMessage, PingMessage, PongMessage, ResetMessage:
+		return enc.flush()
+	}
+	if !enc.armed {
+		enc.armed = true
+		if enc.timer == nil {
+			enc.timer = time.AfterFunc(enc.delay, enc.timedFlush)
+		} else {
+			enc.timer.Reset(enc.delay)
+		}
+	}
+	return nil
+}
+
+// timedFlush writes the batch once its delay has passed.
+func (enc *Encoder) timedFlush() {
+	enc.Lock()
+	defer enc.Unlock()
+	enc.flush()
+}
+
+// flush writes the batch, if any, in one call.
+func (enc *Encoder) flush() error {
+	if enc.err != nil || len(enc.batch) == 0 {
+		return enc.err
+	}
+	if enc.armed {
+		enc.armed = false
+		enc.timer.Stop()
+	}
+	_, err := enc.w.Write(enc.batch)
+	enc.batch = enc.batch[:0]
+	enc.err = err
+	return err
+}
+
+// appendDataHeader appends the header of a data message to b.
+func appendDataHeader(b []byte, msg DataMessage) []byte {
+	b = append(b, msgChannelData, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-8:], msg.ChannelID)
+	binary.BigEndian.PutUint32(b[len(b)-4:], msg.Length)
+	return b
+}
@@ -1,9 +1,40 @@
 // Package frame implements encoding and decoding of qmux message frames.
 package frame
 
-import "io"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
 
 var (
 	// Debug can be set to get message frames as they're encoded and decoded
 	Debug io.Writer
 )
+
+// maxStringLength limits the size of strings and extra data
+// in messages other than DataMessage.
+const maxStringLength = 1 << 16
+
+// readString reads a length prefixed byte string.
+func readString(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxStringLength {
+		return nil, fmt.Errorf("qtalk: string too long: %d bytes", length)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// writeString writes a length prefixed byte string.
+func writeString(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
@@ -2,7 +2,12 @@ package frame
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestEncodeDecode(t *testing.T) {
@@ -43,6 +48,17 @@ func TestEncodeDecode(t *testing.T) {
 			id: 0,
 			ok: false,
 		},
+		{
+			in: OpenMessage{
+				SenderID:      10,
+				WindowSize:    1024,
+				MaxPacketSize: 1 << 31,
+				ChannelType:   "tunnel",
+				Extra:         []byte("localhost:80"),
+			},
+			id: 0,
+			ok: false,
+		},
 		{
 			in: OpenConfirmMessage{
 				ChannelID:     20,
@@ -60,6 +76,15 @@ func TestEncodeDecode(t *testing.T) {
 			id: 20,
 			ok: true,
 		},
+		{
+			in: OpenFailureMessage{
+				ChannelID:   20,
+				Reason:      4,
+				Description: "accept timeout",
+			},
+			id: 20,
+			ok: true,
+		},
 		{
 			in: WindowAdjustMessage{
 				ChannelID:       20,
@@ -68,6 +93,58 @@ func TestEncodeDecode(t *testing.T) {
 			id: 20,
 			ok: true,
 		},
+		{
+			in: ResetMessage{
+				ChannelID: 20,
+				Code:      8,
+			},
+			id: 20,
+			ok: true,
+		},
+		{
+			in: PingMessage{
+				PingID: 5,
+			},
+			id: 0,
+			ok: false,
+		},
+		{
+			in: PongMessage{
+				PingID: 5,
+			},
+			id: 0,
+			ok: false,
+		},
+		{
+			in: GoAwayMessage{},
+			id: 0,
+			ok: false,
+		},
+		{
+			in: GlobalRequestMessage{
+				RequestID: 3,
+				Name:      "reload",
+				WantReply: true,
+				Payload:   []byte("config.json"),
+			},
+			id: 0,
+			ok: false,
+		},
+		{
+			in: RequestSuccessMessage{
+				RequestID: 3,
+				Payload:   []byte("ok"),
+			},
+			id: 0,
+			ok: false,
+		},
+		{
+			in: RequestFailureMessage{
+				RequestID: 3,
+			},
+			id: 0,
+			ok: false,
+		},
 	}
 	for _, test := range tests {
 		var buf bytes.Buffer
@@ -90,6 +167,193 @@ func TestEncodeDecode(t *testing.T) {
 		if m.String() == "" {
 			t.Fatal("empty string representation")
 		}
+		if got := reflect.ValueOf(m).Elem().Interface(); !reflect.DeepEqual(got, test.in) {
+			t.Fatalf("decoded %v, expected %v", got, test.in)
+		}
+	}
+}
+
+func TestOpenFailureCompat(t *testing.T) {
+	// a failure without a reason uses the original encoding
+	b := OpenFailureMessage{ChannelID: 20}.Bytes()
+	if want := []byte{msgChannelOpenFailure, 0, 0, 0, 20}; !bytes.Equal(b, want) {
+		t.Fatalf("encoded %v, expected %v", b, want)
+	}
+
+	// and decodes without reading into the frame after it
+	var buf bytes.Buffer
+	buf.Write(b)
+	buf.Write(CloseMessage{ChannelID: 20}.Bytes())
+	dec := NewDecoder(&buf)
+	for _, want := range []Message{&OpenFailureMessage{ChannelID: 20}, &CloseMessage{ChannelID: 20}} {
+		m, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, want) {
+			t.Fatalf("decoded %v, expected %v", m, want)
+		}
+	}
+
+	if b := (OpenFailureMessage{ChannelID: 20, Reason: 4}).Bytes(); b[0] != msgChannelOpenFailureReason {
+		t.Fatalf("failure with a reason encoded as message type %d", b[0])
+	}
+}
+
+func TestDecodeMaxDataLength(t *testing.T) {
+	// a data frame claiming a 4GB payload with none following
+	var buf bytes.Buffer
+	buf.WriteByte(msgChannelData)
+	binary.Write(&buf, binary.BigEndian, uint32(10))
+	binary.Write(&buf, binary.BigEndian, uint32(1<<32-1))
+	if _, err := NewDecoder(&buf).Decode(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got: %v", err)
+	}
+
+	buf.Reset()
+	if err := NewEncoder(&buf).Encode(DataMessage{ChannelID: 10, Length: 5, Data: []byte("Hello")}); err != nil {
+		t.Fatal(err)
+	}
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+	dec.MaxDataLength = 4
+	if _, err := dec.Decode(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got: %v", err)
+	}
+	dec = NewDecoder(bytes.NewReader(buf.Bytes()))
+	dec.MaxDataLength = 5
+	if _, err := dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func FuzzDecoder(f *testing.F) {
+	for _, msg := range []Message{
+		OpenMessage{SenderID: 10, WindowSize: 1024, MaxPacketSize: 1 << 15, ChannelType: "tunnel", Extra: []byte("localhost:80")},
+		OpenConfirmMessage{ChannelID: 20, SenderID: 10, WindowSize: 1024, MaxPacketSize: 1 << 15},
+		OpenFailureMessage{ChannelID: 20},
+		OpenFailureMessage{ChannelID: 20, Reason: 4, Description: "accept timeout"},
+		DataMessage{ChannelID: 10, Length: 5, Data: []byte("Hello")},
+		WindowAdjustMessage{ChannelID: 20, AdditionalBytes: 1024},
+		EOFMessage{ChannelID: 10},
+		CloseMessage{ChannelID: 10},
+		ResetMessage{ChannelID: 10, Code: 8},
+		GlobalRequestMessage{RequestID: 3, Name: "reload", WantReply: true, Payload: []byte("config.json")},
+		PingMessage{PingID: 5},
+	} {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(msg); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		dec := NewDecoder(bytes.NewReader(b))
+		dec.MaxDataLength = 1 << 16
+		for {
+			msg, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			// anything decoded must survive encoding and decoding again
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf).Encode(msg); err != nil {
+				t.Fatal(err)
+			}
+			again, err := NewDecoder(&buf).Decode()
+			if err != nil {
+				t.Fatalf("re-decoding %v: %v", msg, err)
+			}
+			if !reflect.DeepEqual(again, msg) {
+				t.Fatalf("decoded %v, expected %v", again, msg)
+			}
+		}
+	})
+}
+
+// countingWriter counts the writes made to a buffer.
+type countingWriter struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func (w *countingWriter) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writes
+}
+
+func TestBatchEncoder(t *testing.T) {
+	w := &countingWriter{}
+	enc := NewBatchEncoder(w, time.Hour, 64)
+	data := DataMessage{ChannelID: 1, Length: 5, Data: []byte("Hello")}
+	for i := 0; i < 3; i++ {
+		if err := enc.Encode(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.count() != 0 {
+		t.Fatal("batched frames were written before the delay")
 	}
 
+	// latency sensitive frames are written right away with the batch
+	if err := enc.Encode(PingMessage{PingID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if w.count() != 1 {
+		t.Fatalf("unexpected writes: %d", w.count())
+	}
+
+	// going past the limit writes the batch first, and frames
+	// larger than the limit are written on their own
+	for i := 0; i < 5; i++ {
+		if err := enc.Encode(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Encode(DataMessage{ChannelID: 1, Length: 64, Data: make([]byte, 64)}); err != nil {
+		t.Fatal(err)
+	}
+	if w.count() != 4 {
+		t.Fatalf("unexpected writes: %d", w.count())
+	}
+	if err := enc.Encode(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.count() != 5 {
+		t.Fatalf("unexpected writes: %d", w.count())
+	}
+
+	dec := NewDecoder(&w.buf)
+	for i := 0; i < 11; i++ {
+		if _, err := dec.Decode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.buf.Len() != 0 {
+		t.Fatal("unexpected bytes written")
+	}
+
+	// the batch is written once the delay has passed
+	enc = NewBatchEncoder(w, 10*time.Millisecond, 64)
+	if err := enc.Encode(data); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for w.count() != 6 {
+		if time.Now().After(deadline) {
+			t.Fatal("batch was not written after the delay")
+		}
+		time.Sleep(time.Millisecond)
+	}
 }
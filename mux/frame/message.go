@@ -8,6 +8,15 @@ const (
 	msgChannelData
 	msgChannelEOF
 	msgChannelClose
+	msgPing
+	msgPong
+	msgGoAway
+	msgChannelOpenTyped
+	msgGlobalRequest
+	msgRequestSuccess
+	msgRequestFailure
+	msgChannelReset
+	msgChannelOpenFailureReason
 )
 
 type Message interface {
@@ -3,6 +3,7 @@ package frame
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 )
 
 type DataMessage struct {
@@ -27,3 +28,30 @@ func (msg DataMessage) Bytes() []byte {
 	binary.BigEndian.PutUint32(packet[5:9], msg.Length)
 	return append(packet, msg.Data...)
 }
+
+// decodeData decodes a data message, failing with ErrFrameTooLarge
+// before allocating a payload larger than MaxDataLength. The payload
+// is read into a buffer from Alloc if it is set.
+func (dec *Decoder) decodeData(msg *DataMessage) error {
+	header := dec.scratch[:8]
+	if _, err := io.ReadFull(dec.r, header); err != nil {
+		return err
+	}
+	msg.ChannelID = binary.BigEndian.Uint32(header[0:4])
+	msg.Length = binary.BigEndian.Uint32(header[4:8])
+	max := dec.MaxDataLength
+	if max == 0 {
+		max = DefaultMaxDataLength
+	}
+	if msg.Length > max {
+		return fmt.Errorf("%w: %d byte payload", ErrFrameTooLarge, msg.Length)
+	}
+	if dec.Alloc != nil {
+		msg.Data = dec.Alloc(msg.ChannelID, int(msg.Length))
+	}
+	if msg.Data == nil {
+		msg.Data = make([]byte, msg.Length)
+	}
+	_, err := io.ReadFull(dec.r, msg.Data)
+	return err
+}
@@ -0,0 +1,56 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type GlobalRequestMessage struct {
+	RequestID uint32
+	Name      string
+	WantReply bool
+	Payload   []byte
+}
+
+func (msg GlobalRequestMessage) String() string {
+	return fmt.Sprintf("{GlobalRequestMessage RequestID:%d Name:%q WantReply:%t Payload:%d bytes}",
+		msg.RequestID, msg.Name, msg.WantReply, len(msg.Payload))
+}
+
+func (msg GlobalRequestMessage) Channel() (uint32, bool) {
+	return 0, false
+}
+
+func (msg GlobalRequestMessage) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(msgGlobalRequest)
+	binary.Write(buf, binary.BigEndian, msg.RequestID)
+	writeString(buf, []byte(msg.Name))
+	binary.Write(buf, binary.BigEndian, msg.WantReply)
+	writeString(buf, msg.Payload)
+	return buf.Bytes()
+}
+
+func (msg *GlobalRequestMessage) decode(_ byte, r io.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, &msg.RequestID); err != nil {
+		return err
+	}
+	name, err := readString(r)
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &msg.WantReply); err != nil {
+		return err
+	}
+	payload, err := readString(r)
+	if err != nil {
+		return err
+	}
+	msg.Name = string(name)
+	if len(payload) > 0 {
+		msg.Payload = payload
+	}
+	return nil
+}
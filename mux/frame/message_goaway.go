@@ -0,0 +1,15 @@
+package frame
+
+type GoAwayMessage struct{}
+
+func (msg GoAwayMessage) String() string {
+	return "{GoAwayMessage}"
+}
+
+func (msg GoAwayMessage) Channel() (uint32, bool) {
+	return 0, false
+}
+
+func (msg GoAwayMessage) Bytes() []byte {
+	return []byte{msgGoAway}
+}
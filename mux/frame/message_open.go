@@ -4,15 +4,25 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 )
 
+// OpenMessage opens a channel. If ChannelType or Extra are set, it is
+// encoded as a typed open, otherwise it uses the original anonymous open
+// encoding so it stays compatible with peers that don't know channel types.
 type OpenMessage struct {
 	SenderID      uint32
 	WindowSize    uint32
 	MaxPacketSize uint32
+	ChannelType   string
+	Extra         []byte
 }
 
 func (msg OpenMessage) String() string {
+	if msg.typed() {
+		return fmt.Sprintf("{OpenMessage SenderID:%d WindowSize:%d MaxPacketSize:%d ChannelType:%q Extra:%d bytes}",
+			msg.SenderID, msg.WindowSize, msg.MaxPacketSize, msg.ChannelType, len(msg.Extra))
+	}
 	return fmt.Sprintf("{OpenMessage SenderID:%d WindowSize:%d MaxPacketSize:%d}",
 		msg.SenderID, msg.WindowSize, msg.MaxPacketSize)
 }
@@ -23,7 +33,48 @@ func (msg OpenMessage) Channel() (uint32, bool) {
 
 func (msg OpenMessage) Bytes() []byte {
 	buf := new(bytes.Buffer)
-	buf.WriteByte(msgChannelOpen)
-	binary.Write(buf, binary.BigEndian, msg)
+	if !msg.typed() {
+		buf.WriteByte(msgChannelOpen)
+		binary.Write(buf, binary.BigEndian, []uint32{msg.SenderID, msg.WindowSize, msg.MaxPacketSize})
+		return buf.Bytes()
+	}
+	buf.WriteByte(msgChannelOpenTyped)
+	binary.Write(buf, binary.BigEndian, []uint32{msg.SenderID, msg.WindowSize, msg.MaxPacketSize})
+	writeString(buf, []byte(msg.ChannelType))
+	writeString(buf, msg.Extra)
 	return buf.Bytes()
 }
+
+func (msg OpenMessage) typed() bool {
+	return msg.ChannelType != "" || len(msg.Extra) > 0
+}
+
+func (msg *OpenMessage) decode(msgNum byte, r io.Reader) error {
+	var open struct {
+		SenderID      uint32
+		WindowSize    uint32
+		MaxPacketSize uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &open); err != nil {
+		return err
+	}
+	msg.SenderID = open.SenderID
+	msg.WindowSize = open.WindowSize
+	msg.MaxPacketSize = open.MaxPacketSize
+	if msgNum != msgChannelOpenTyped {
+		return nil
+	}
+	chanType, err := readString(r)
+	if err != nil {
+		return err
+	}
+	extra, err := readString(r)
+	if err != nil {
+		return err
+	}
+	msg.ChannelType = string(chanType)
+	if len(extra) > 0 {
+		msg.Extra = extra
+	}
+	return nil
+}
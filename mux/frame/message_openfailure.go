@@ -4,14 +4,25 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 )
 
+// OpenFailureMessage refuses a channel open. If Reason or Description are
+// set, it is encoded as a failure with a reason, otherwise it uses the
+// original encoding so it stays compatible with peers that don't know
+// failure reasons.
 type OpenFailureMessage struct {
-	ChannelID uint32
+	ChannelID   uint32
+	Reason      uint32
+	Description string
 }
 
 func (msg OpenFailureMessage) String() string {
-	return fmt.Sprintf("{OpenFailureMessage ChannelID:%d}", msg.ChannelID)
+	if !msg.hasReason() {
+		return fmt.Sprintf("{OpenFailureMessage ChannelID:%d}", msg.ChannelID)
+	}
+	return fmt.Sprintf("{OpenFailureMessage ChannelID:%d Reason:%d Description:%q}",
+		msg.ChannelID, msg.Reason, msg.Description)
 }
 
 func (msg OpenFailureMessage) Channel() (uint32, bool) {
@@ -20,7 +31,38 @@ func (msg OpenFailureMessage) Channel() (uint32, bool) {
 
 func (msg OpenFailureMessage) Bytes() []byte {
 	buf := new(bytes.Buffer)
-	buf.WriteByte(msgChannelOpenFailure)
-	binary.Write(buf, binary.BigEndian, msg)
+	if !msg.hasReason() {
+		buf.WriteByte(msgChannelOpenFailure)
+		binary.Write(buf, binary.BigEndian, msg.ChannelID)
+		return buf.Bytes()
+	}
+	buf.WriteByte(msgChannelOpenFailureReason)
+	binary.Write(buf, binary.BigEndian, []uint32{msg.ChannelID, msg.Reason})
+	writeString(buf, []byte(msg.Description))
 	return buf.Bytes()
 }
+
+func (msg OpenFailureMessage) hasReason() bool {
+	return msg.Reason != 0 || msg.Description != ""
+}
+
+func (msg *OpenFailureMessage) decode(msgNum byte, r io.Reader) error {
+	if msgNum != msgChannelOpenFailureReason {
+		return binary.Read(r, binary.BigEndian, &msg.ChannelID)
+	}
+	var failure struct {
+		ChannelID uint32
+		Reason    uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &failure); err != nil {
+		return err
+	}
+	desc, err := readString(r)
+	if err != nil {
+		return err
+	}
+	msg.ChannelID = failure.ChannelID
+	msg.Reason = failure.Reason
+	msg.Description = string(desc)
+	return nil
+}
@@ -0,0 +1,26 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type PongMessage struct {
+	PingID uint32
+}
+
+func (msg PongMessage) String() string {
+	return fmt.Sprintf("{PongMessage PingID:%d}", msg.PingID)
+}
+
+func (msg PongMessage) Channel() (uint32, bool) {
+	return 0, false
+}
+
+func (msg PongMessage) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(msgPong)
+	binary.Write(buf, binary.BigEndian, msg)
+	return buf.Bytes()
+}
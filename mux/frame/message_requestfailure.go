@@ -0,0 +1,44 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type RequestFailureMessage struct {
+	RequestID uint32
+	Payload   []byte
+}
+
+func (msg RequestFailureMessage) String() string {
+	return fmt.Sprintf("{RequestFailureMessage RequestID:%d Payload:%d bytes}",
+		msg.RequestID, len(msg.Payload))
+}
+
+func (msg RequestFailureMessage) Channel() (uint32, bool) {
+	return 0, false
+}
+
+func (msg RequestFailureMessage) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(msgRequestFailure)
+	binary.Write(buf, binary.BigEndian, msg.RequestID)
+	writeString(buf, msg.Payload)
+	return buf.Bytes()
+}
+
+func (msg *RequestFailureMessage) decode(_ byte, r io.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, &msg.RequestID); err != nil {
+		return err
+	}
+	payload, err := readString(r)
+	if err != nil {
+		return err
+	}
+	if len(payload) > 0 {
+		msg.Payload = payload
+	}
+	return nil
+}
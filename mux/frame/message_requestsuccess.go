@@ -0,0 +1,44 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type RequestSuccessMessage struct {
+	RequestID uint32
+	Payload   []byte
+}
+
+func (msg RequestSuccessMessage) String() string {
+	return fmt.Sprintf("{RequestSuccessMessage RequestID:%d Payload:%d bytes}",
+		msg.RequestID, len(msg.Payload))
+}
+
+func (msg RequestSuccessMessage) Channel() (uint32, bool) {
+	return 0, false
+}
+
+func (msg RequestSuccessMessage) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(msgRequestSuccess)
+	binary.Write(buf, binary.BigEndian, msg.RequestID)
+	writeString(buf, msg.Payload)
+	return buf.Bytes()
+}
+
+func (msg *RequestSuccessMessage) decode(_ byte, r io.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, &msg.RequestID); err != nil {
+		return err
+	}
+	payload, err := readString(r)
+	if err != nil {
+		return err
+	}
+	if len(payload) > 0 {
+		msg.Payload = payload
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ResetMessage aborts a channel, discarding the data buffered for it
+// in both directions. Code is an application defined error code.
+type ResetMessage struct {
+	ChannelID uint32
+	Code      uint32
+}
+
+func (msg ResetMessage) String() string {
+	return fmt.Sprintf("{ResetMessage ChannelID:%d Code:%d}", msg.ChannelID, msg.Code)
+}
+
+func (msg ResetMessage) Channel() (uint32, bool) {
+	return msg.ChannelID, true
+}
+
+func (msg ResetMessage) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(msgChannelReset)
+	binary.Write(buf, binary.BigEndian, msg)
+	return buf.Bytes()
+}
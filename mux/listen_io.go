@@ -9,11 +9,12 @@ import (
 // ioListener wraps a single ReadWriteCloser to use as a listener.
 type ioListener struct {
 	io.ReadWriteCloser
+	cfg Config
 }
 
 // Accept will always return the wrapped ReadWriteCloser as a mux session.
 func (l *ioListener) Accept() (Session, error) {
-	return New(l.ReadWriteCloser), nil
+	return NewWithConfig(l.ReadWriteCloser, l.cfg), nil
 }
 
 func (l *ioListener) Addr() net.Addr {
@@ -38,8 +39,14 @@ func (d *ioduplex) Close() error {
 // ListenIO returns an IOListener that gives a mux session based on seperate
 // WriteCloser and ReadClosers.
 func ListenIO(out io.WriteCloser, in io.ReadCloser) (Listener, error) {
+	return ListenIOWithConfig(out, in, Config{})
+}
+
+// ListenIOWithConfig is like ListenIO but its session uses the settings in cfg.
+func ListenIOWithConfig(out io.WriteCloser, in io.ReadCloser, cfg Config) (Listener, error) {
 	return &ioListener{
-		&ioduplex{out, in},
+		ReadWriteCloser: &ioduplex{out, in},
+		cfg:             cfg,
 	}, nil
 }
 
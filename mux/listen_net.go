@@ -1,12 +1,14 @@
 package mux
 
 import (
+	"crypto/tls"
 	"net"
 )
 
 // netListener wraps a net.Listener to return connected mux sessions.
 type netListener struct {
 	net.Listener
+	cfg Config
 }
 
 // Accept waits for and returns the next connected session to the listener.
@@ -15,7 +17,7 @@ func (l *netListener) Accept() (Session, error) {
 	if err != nil {
 		return nil, err
 	}
-	return New(conn), nil
+	return NewWithConfig(conn, l.cfg), nil
 }
 
 // Close closes the listener.
@@ -34,11 +36,34 @@ func ListenerFrom(l net.Listener) Listener {
 
 // ListenTCP creates a TCP listener at the given address.
 func ListenTCP(addr string) (Listener, error) {
+	return ListenTCPWithConfig(addr, Config{})
+}
+
+// ListenTCPWithConfig creates a TCP listener at the given address
+// whose sessions use the settings in cfg.
+func ListenTCPWithConfig(addr string, cfg Config) (Listener, error) {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	return ListenerFrom(l), nil
+	return &netListener{Listener: l, cfg: cfg}, nil
+}
+
+// ListenTLS creates a TLS listener at the given address. The config must
+// have at least one certificate. For mutual TLS, set its ClientAuth and
+// ClientCAs to require and verify client certificates.
+func ListenTLS(addr string, config *tls.Config) (Listener, error) {
+	return ListenTLSWithConfig(addr, config, Config{})
+}
+
+// ListenTLSWithConfig creates a TLS listener at the given address
+// whose sessions use the settings in cfg.
+func ListenTLSWithConfig(addr string, config *tls.Config, cfg Config) (Listener, error) {
+	l, err := tls.Listen("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return &netListener{Listener: l, cfg: cfg}, nil
 }
 
 // ListenTCP creates a Unix domain socket listener at the given path.
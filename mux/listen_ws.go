@@ -1,6 +1,7 @@
 package mux
 
 import (
+	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
@@ -36,10 +37,33 @@ func (l *wsListener) Addr() net.Addr {
 
 // ListenWS takes a TCP address and returns a Listener for a HTTP+WebSocket server listening on the given address.
 func ListenWS(addr string) (Listener, error) {
+	return ListenWSWithConfig(addr, Config{})
+}
+
+// ListenWSWithConfig is like ListenWS but its sessions use the settings in cfg.
+func ListenWSWithConfig(addr string, cfg Config) (Listener, error) {
+	return listenWS(addr, nil, cfg)
+}
+
+// ListenWSS is like ListenWS but serves WebSocket connections over TLS
+// using config, which must have at least one certificate.
+func ListenWSS(addr string, config *tls.Config) (Listener, error) {
+	return ListenWSSWithConfig(addr, config, Config{})
+}
+
+// ListenWSSWithConfig is like ListenWSS but its sessions use the settings in cfg.
+func ListenWSSWithConfig(addr string, config *tls.Config, cfg Config) (Listener, error) {
+	return listenWS(addr, config, cfg)
+}
+
+func listenWS(addr string, config *tls.Config, cfg Config) (Listener, error) {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	if config != nil {
+		l = tls.NewListener(l, config)
+	}
 	wsl := &wsListener{
 		Listener: l,
 		accepted: make(chan Session),
@@ -48,7 +72,14 @@ func ListenWS(addr string) (Listener, error) {
 		Addr: addr,
 		Handler: websocket.Handler(func(ws *websocket.Conn) {
 			ws.PayloadType = websocket.BinaryFrame
-			sess := New(ws)
+			var t io.ReadWriteCloser = ws
+			if state := ws.Request().TLS; state != nil {
+				t = &tlsTransport{
+					ReadWriteCloser: ws,
+					state:           func() tls.ConnectionState { return *state },
+				}
+			}
+			sess := NewWithConfig(t, cfg)
 			defer sess.Close()
 			wsl.accepted <- sess
 			sess.Wait()
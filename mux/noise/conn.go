@@ -0,0 +1,95 @@
+package noise
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/flynn/noise"
+)
+
+// Conn is an encrypted transport established by a Noise handshake.
+// Data is sent in messages of up to 64KB, each with its own length
+// prefix and authentication tag.
+type Conn struct {
+	rw        io.ReadWriteCloser
+	remoteKey []byte
+
+	readMu  sync.Mutex
+	recv    *noise.CipherState
+	msgBuf  []byte
+	plain   []byte
+	pending []byte // decrypted data not yet read
+	readErr error
+
+	writeMu  sync.Mutex
+	send     *noise.CipherState
+	writeBuf []byte
+}
+
+// RemoteKey returns the static public key of the other end.
+func (c *Conn) RemoteKey() []byte {
+	return c.remoteKey
+}
+
+// Read reads decrypted data from the transport. It fails with ErrDecrypt
+// if a message was tampered with, after which all reads fail.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if c.msgBuf == nil {
+			c.msgBuf = make([]byte, maxMessageLen)
+		}
+		msg, err := readMessage(c.rw, c.msgBuf)
+		if err != nil {
+			c.readErr = err
+			continue
+		}
+		c.plain, err = c.recv.Decrypt(c.plain[:0], nil, msg)
+		if err != nil {
+			c.readErr = readError(err)
+			continue
+		}
+		c.pending = c.plain
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write encrypts p and writes it to the transport, split into as
+// many messages as needed.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxMessageLen-tagLen {
+			chunk = chunk[:maxMessageLen-tagLen]
+		}
+		buf, err := c.send.Encrypt(append(c.writeBuf[:0], 0, 0), nil, chunk)
+		if err != nil {
+			return n, err
+		}
+		binary.BigEndian.PutUint16(buf, uint16(len(buf)-2))
+		c.writeBuf = buf
+		if _, err := c.rw.Write(buf); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close closes the underlying transport.
+func (c *Conn) Close() error {
+	return c.rw.Close()
+}
@@ -0,0 +1,269 @@
+// Package noise implements an encrypted transport for mux sessions using
+// the Noise protocol framework with the XX and IK handshake patterns,
+// Curve25519, ChaCha20-Poly1305 and SHA-256, as implemented by
+// github.com/flynn/noise.
+//
+// It's meant for transports without their own encryption, such as stdio,
+// pipes and serial links. Each end has a static key pair, and the static
+// public key of the other end can be pinned. Use ClientHandshaker and
+// ServerHandshaker in a mux.Config to encrypt a session:
+//
+//	sess, err := mux.DialIOWithConfig(out, in, mux.Config{
+//		Handshaker: noise.ClientHandshaker(noise.Config{
+//			StaticKey: key,
+//			RemoteKey: serverPublicKey,
+//		}),
+//	})
+package noise
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/flynn/noise"
+	"github.com/progrium/qtalk-go/mux"
+)
+
+var (
+	// ErrKeyMismatch is returned when the static key of the other end
+	// doesn't match Config.RemoteKey.
+	ErrKeyMismatch = errors.New("noise: remote static key does not match pinned key")
+
+	// ErrDecrypt is returned when a message fails to decrypt, meaning it
+	// was tampered with or the ends don't share the same keys.
+	ErrDecrypt = errors.New("noise: message authentication failed")
+
+	// ErrShortMessage is returned when a handshake message is too short
+	// for the handshake pattern.
+	ErrShortMessage = noise.ErrShortMessage
+)
+
+const (
+	// maxMessageLen is the largest Noise message, which limits the plaintext
+	// sent in one message to maxMessageLen minus the authentication tag.
+	maxMessageLen = 65535
+	dhLen         = 32
+	tagLen        = 16
+)
+
+var cipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256)
+
+// A Pattern is a Noise handshake pattern.
+type Pattern string
+
+const (
+	// XX transmits both static keys during the handshake. Neither end
+	// needs to know the other's key in advance, so pinning is optional.
+	XX Pattern = "XX"
+
+	// IK has the initiator send its static key in the first message,
+	// encrypted to the responder's static key, which the initiator must
+	// know in advance. It saves a round trip over XX.
+	IK Pattern = "IK"
+)
+
+func (p Pattern) handshake() noise.HandshakePattern {
+	if p == IK {
+		return noise.HandshakeIK
+	}
+	return noise.HandshakeXX
+}
+
+// A Key is a Curve25519 key pair.
+type Key struct {
+	Private []byte
+	Public  []byte
+}
+
+// GenerateKey returns a new random key pair.
+func GenerateKey() (Key, error) {
+	key, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{Private: key.Private, Public: key.Public}, nil
+}
+
+// Config holds the settings for one end of a Noise handshake.
+type Config struct {
+	// Pattern is the handshake pattern, which defaults to XX.
+	Pattern Pattern
+
+	// StaticKey is the static key pair of this end. It is required.
+	StaticKey Key
+
+	// RemoteKey pins the static public key of the other end, failing the
+	// handshake with ErrKeyMismatch if the other end has a different one.
+	// It is required by IK initiators.
+	RemoteKey []byte
+
+	// VerifyKey, if set, is called with the static public key of the other
+	// end as soon as it is received, such as to check it against a set of
+	// pinned keys. Returning an error fails the handshake.
+	VerifyKey func(publicKey []byte) error
+
+	// Prologue is optional data both ends must agree on, such as a protocol
+	// version. The handshake fails if the ends have different prologues.
+	Prologue []byte
+}
+
+func (c Config) pattern() Pattern {
+	if c.Pattern == "" {
+		return XX
+	}
+	return c.Pattern
+}
+
+func (c Config) verifyKey(key []byte) error {
+	if c.RemoteKey != nil && !bytes.Equal(key, c.RemoteKey) {
+		return ErrKeyMismatch
+	}
+	if c.VerifyKey != nil {
+		return c.VerifyKey(key)
+	}
+	return nil
+}
+
+// Client runs the initiator side of a handshake over rw and returns
+// a Conn that encrypts everything written to rw.
+func Client(rw io.ReadWriteCloser, cfg Config) (*Conn, error) {
+	return handshake(rw, cfg, true)
+}
+
+// Server runs the responder side of a handshake over rw and returns
+// a Conn that encrypts everything written to rw.
+func Server(rw io.ReadWriteCloser, cfg Config) (*Conn, error) {
+	return handshake(rw, cfg, false)
+}
+
+// ClientHandshaker returns a mux.Handshaker that runs the initiator side
+// of a handshake when a session starts, for the dialing end of a transport.
+func ClientHandshaker(cfg Config) mux.Handshaker {
+	return handshaker{cfg: cfg, initiator: true}
+}
+
+// ServerHandshaker returns a mux.Handshaker that runs the responder side
+// of a handshake when a session starts, for the accepting end of a transport.
+func ServerHandshaker(cfg Config) mux.Handshaker {
+	return handshaker{cfg: cfg}
+}
+
+type handshaker struct {
+	cfg       Config
+	initiator bool
+}
+
+func (h handshaker) Handshake(t io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	return handshake(t, h.cfg, h.initiator)
+}
+
+func handshake(rw io.ReadWriteCloser, cfg Config, initiator bool) (*Conn, error) {
+	pattern := cfg.pattern()
+	if pattern != XX && pattern != IK {
+		return nil, fmt.Errorf("noise: unsupported pattern %q", pattern)
+	}
+	if len(cfg.StaticKey.Private) != dhLen || len(cfg.StaticKey.Public) != dhLen {
+		return nil, errors.New("noise: invalid static key")
+	}
+	var rs []byte
+	if pattern == IK && initiator {
+		if len(cfg.RemoteKey) != dhLen {
+			return nil, errors.New("noise: IK initiator requires the remote static key")
+		}
+		rs = cfg.RemoteKey
+	}
+
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   cipherSuite,
+		Pattern:       pattern.handshake(),
+		Initiator:     initiator,
+		Prologue:      cfg.Prologue,
+		StaticKeypair: noise.DHKey{Private: cfg.StaticKey.Private, Public: cfg.StaticKey.Public},
+		PeerStatic:    rs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	verified := rs != nil
+	write := initiator
+	buf := make([]byte, maxMessageLen)
+	var c1, c2 *noise.CipherState
+	for c1 == nil {
+		if write {
+			var msg []byte
+			msg, c1, c2, err = hs.WriteMessage(nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeMessage(rw, msg); err != nil {
+				return nil, err
+			}
+		} else {
+			msg, err := readMessage(rw, buf)
+			if err != nil {
+				return nil, err
+			}
+			if _, c1, c2, err = hs.ReadMessage(nil, msg); err != nil {
+				return nil, readError(err)
+			}
+			// The key is verified as soon as it arrives so that
+			// this end doesn't send its own static key to an
+			// unexpected peer.
+			if rs := hs.PeerStatic(); !verified && rs != nil {
+				if err := cfg.verifyKey(rs); err != nil {
+					return nil, err
+				}
+				verified = true
+			}
+		}
+		write = !write
+	}
+
+	// c1 encrypts from the initiator to the responder, and c2 the other way
+	c := &Conn{rw: rw, remoteKey: hs.PeerStatic(), send: c1, recv: c2}
+	if !initiator {
+		c.send, c.recv = c2, c1
+	}
+	return c, nil
+}
+
+// readError returns the error for a message that failed to be read by
+// a handshake or cipher state. Anything but a short message means the
+// message failed to authenticate.
+func readError(err error) error {
+	if err == noise.ErrShortMessage || err == noise.ErrMaxNonce {
+		return err
+	}
+	return ErrDecrypt
+}
+
+// readMessage reads a length prefixed message into buf.
+func readMessage(r io.Reader, buf []byte) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := buf[:binary.BigEndian.Uint16(length[:])]
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeMessage writes a length prefixed message.
+func writeMessage(w io.Writer, msg []byte) error {
+	if len(msg) > maxMessageLen {
+		return fmt.Errorf("noise: message too long: %d bytes", len(msg))
+	}
+	buf := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
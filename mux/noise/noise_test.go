@@ -0,0 +1,228 @@
+package noise
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/progrium/qtalk-go/mux"
+)
+
+func fatal(err error, t *testing.T) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newKey(t *testing.T) Key {
+	t.Helper()
+	key, err := GenerateKey()
+	fatal(err, t)
+	return key
+}
+
+type duplex struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// tap records everything written through it.
+type tap struct {
+	io.WriteCloser
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (t *tap) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.buf.Write(p)
+	t.mu.Unlock()
+	return t.WriteCloser.Write(p)
+}
+
+func (t *tap) Bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]byte(nil), t.buf.Bytes()...)
+}
+
+// handshakePair runs a handshake between client and server configs
+// over in-memory pipes.
+func handshakePair(client, server Config) (*Conn, *Conn, error, error) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := Server(&duplex{br, bw}, server)
+		if err != nil {
+			bw.Close()
+		}
+		done <- result{conn, err}
+	}()
+	connA, errA := Client(&duplex{ar, aw}, client)
+	if errA != nil {
+		aw.Close()
+	}
+	res := <-done
+	return connA, res.conn, errA, res.err
+}
+
+func TestSession(t *testing.T) {
+	for _, pattern := range []Pattern{XX, IK} {
+		t.Run(string(pattern), func(t *testing.T) {
+			clientKey, serverKey := newKey(t), newKey(t)
+
+			ar, bw := io.Pipe()
+			br, aw := io.Pipe()
+			wire := &tap{WriteCloser: aw}
+
+			l, err := mux.ListenIOWithConfig(bw, br, mux.Config{
+				Handshaker: ServerHandshaker(Config{
+					Pattern:   pattern,
+					StaticKey: serverKey,
+					RemoteKey: clientKey.Public,
+				}),
+			})
+			fatal(err, t)
+			sessB, err := l.Accept()
+			fatal(err, t)
+			defer sessB.Close()
+
+			sessA, err := mux.DialIOWithConfig(wire, ar, mux.Config{
+				Handshaker: ClientHandshaker(Config{
+					Pattern:   pattern,
+					StaticKey: clientKey,
+					RemoteKey: serverKey.Public,
+				}),
+			})
+			fatal(err, t)
+			defer sessA.Close()
+
+			secret := bytes.Repeat([]byte("attack at dawn "), 10000)
+			go func() {
+				ch, err := sessA.Open(context.Background())
+				fatal(err, t)
+				_, err = ch.Write(secret)
+				fatal(err, t)
+				ch.Close()
+			}()
+
+			ch, err := sessB.Accept()
+			fatal(err, t)
+			b, err := io.ReadAll(ch)
+			fatal(err, t)
+			if !bytes.Equal(b, secret) {
+				t.Fatal("unexpected data received")
+			}
+			if bytes.Contains(wire.Bytes(), []byte("attack at dawn")) {
+				t.Fatal("plaintext sent over the wire")
+			}
+		})
+	}
+}
+
+func TestKeyPinning(t *testing.T) {
+	clientKey, serverKey, otherKey := newKey(t), newKey(t), newKey(t)
+	errRejected := errors.New("rejected")
+
+	for _, tt := range []struct {
+		name            string
+		client, server  Config
+		clientErr       error
+		serverErr       error
+		clientRemoteKey []byte
+		serverRemoteKey []byte
+	}{
+		{
+			name:            "unpinned",
+			client:          Config{StaticKey: clientKey},
+			server:          Config{StaticKey: serverKey},
+			clientRemoteKey: serverKey.Public,
+			serverRemoteKey: clientKey.Public,
+		},
+		{
+			name:      "server key mismatch",
+			client:    Config{StaticKey: clientKey, RemoteKey: otherKey.Public},
+			server:    Config{StaticKey: serverKey},
+			clientErr: ErrKeyMismatch,
+		},
+		{
+			name:      "client key mismatch",
+			client:    Config{StaticKey: clientKey},
+			server:    Config{StaticKey: serverKey, RemoteKey: otherKey.Public},
+			serverErr: ErrKeyMismatch,
+		},
+		{
+			name:   "client key rejected",
+			client: Config{StaticKey: clientKey},
+			server: Config{StaticKey: serverKey, VerifyKey: func(key []byte) error {
+				return errRejected
+			}},
+			serverErr: errRejected,
+		},
+		{
+			name:      "IK wrong server key",
+			client:    Config{Pattern: IK, StaticKey: clientKey, RemoteKey: otherKey.Public},
+			server:    Config{Pattern: IK, StaticKey: serverKey},
+			serverErr: ErrDecrypt,
+		},
+		{
+			name:      "prologue mismatch",
+			client:    Config{StaticKey: clientKey, Prologue: []byte("v1")},
+			server:    Config{StaticKey: serverKey, Prologue: []byte("v2")},
+			clientErr: ErrDecrypt,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			connA, connB, errA, errB := handshakePair(tt.client, tt.server)
+			if tt.clientErr != nil && !errors.Is(errA, tt.clientErr) {
+				t.Fatalf("unexpected client error: %v", errA)
+			}
+			if tt.serverErr != nil && !errors.Is(errB, tt.serverErr) {
+				t.Fatalf("unexpected server error: %v", errB)
+			}
+			if tt.clientErr != nil || tt.serverErr != nil {
+				return
+			}
+			fatal(errA, t)
+			fatal(errB, t)
+			if !bytes.Equal(connA.RemoteKey(), tt.clientRemoteKey) {
+				t.Fatal("unexpected client remote key")
+			}
+			if !bytes.Equal(connB.RemoteKey(), tt.serverRemoteKey) {
+				t.Fatal("unexpected server remote key")
+			}
+		})
+	}
+}
+
+func TestTampering(t *testing.T) {
+	clientKey, serverKey := newKey(t), newKey(t)
+	connA, connB, errA, errB := handshakePair(Config{StaticKey: clientKey}, Config{StaticKey: serverKey})
+	fatal(errA, t)
+	fatal(errB, t)
+
+	// replaying a message fails because its nonce has already been used
+	go func() {
+		connA.Write([]byte("hello"))
+		connA.send.SetNonce(connA.send.Nonce() - 1)
+		connA.Write([]byte("hello"))
+	}()
+	b := make([]byte, 5)
+	_, err := io.ReadFull(connB, b)
+	fatal(err, t)
+	if _, err := connB.Read(b); err != ErrDecrypt {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := connB.Read(b); err != ErrDecrypt {
+		t.Fatalf("unexpected error after failure: %v", err)
+	}
+}
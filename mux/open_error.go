@@ -0,0 +1,60 @@
+package mux
+
+import "fmt"
+
+// RejectionReason is the reason code sent to the other end when an
+// incoming channel open is refused. The first four match the SSH
+// channel open failure reason codes.
+type RejectionReason uint32
+
+const (
+	Prohibited RejectionReason = iota + 1
+	ConnectionFailed
+	UnknownChannelType
+	ResourceShortage
+	AcceptTimeout
+	InvalidPacketSize
+	ShuttingDown
+)
+
+// String converts the rejection reason to human readable form.
+func (r RejectionReason) String() string {
+	switch r {
+	case Prohibited:
+		return "administratively prohibited"
+	case ConnectionFailed:
+		return "connect failed"
+	case UnknownChannelType:
+		return "unknown channel type"
+	case ResourceShortage:
+		return "resource shortage"
+	case AcceptTimeout:
+		return "accept timeout"
+	case InvalidPacketSize:
+		return "invalid packet size"
+	case ShuttingDown:
+		return "shutting down"
+	}
+	return fmt.Sprintf("unknown reason %d", int(r))
+}
+
+// OpenError is returned by Open when the other end refuses to open
+// the channel. It can also be returned by a Config.AcceptFilter to
+// refuse an incoming open with a specific reason. The reason is zero
+// if the other end didn't send one, as peers only do once they know
+// open failure reasons are supported.
+type OpenError struct {
+	Reason  RejectionReason
+	Message string
+}
+
+func (e *OpenError) Error() string {
+	if e.Reason == 0 && e.Message == "" {
+		// the other end didn't send a reason
+		return "qmux: channel open failed on remote side"
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("qmux: channel open failed on remote side: %s", e.Reason)
+	}
+	return fmt.Sprintf("qmux: channel open failed on remote side: %s (%s)", e.Message, e.Reason)
+}
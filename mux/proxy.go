@@ -9,7 +9,8 @@ import (
 // Proxy accepts channels on src then opens a channel on dst and performs
 // an io.Copy in both directions in goroutines. Proxy returns non-EOF errors
 // from src.Accept, nil on EOF, and any errors from dst.Open after closing
-// the accepted channel from src.
+// the accepted channel from src. A channel reset on one side is reset on
+// the other with the same code.
 func Proxy(dst, src Session) error {
 	for {
 		ctx := context.Background()
@@ -33,13 +34,15 @@ func proxy(a, b Channel) {
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
-		io.Copy(a, b)
-		a.CloseWrite()
+		if _, err := io.Copy(a, b); !ForwardReset(err, a, b) {
+			a.CloseWrite()
+		}
 		wg.Done()
 	}()
 	go func() {
-		io.Copy(b, a)
-		b.CloseWrite()
+		if _, err := io.Copy(b, a); !ForwardReset(err, a, b) {
+			b.CloseWrite()
+		}
 		wg.Done()
 	}()
 	wg.Wait()
@@ -101,3 +101,17 @@ func TestProxyCloseDst(t *testing.T) {
 		t.Fatal("unexpected proxy error:", err)
 	}
 }
+
+func TestProxyReset(t *testing.T) {
+	cleanup, _, sessA, sessB := setupProxy(t)
+	defer cleanup()
+
+	chA, err := sessA.Open(context.Background())
+	fatal(err, t)
+	chB, err := sessB.Accept()
+	fatal(err, t)
+
+	fatal(chA.(Resetter).Reset(5), t)
+	_, err = io.ReadAll(chB)
+	expectReset(t, err, 5, true)
+}
@@ -0,0 +1,102 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+// ErrChannelReset matches the errors returned by a channel that was reset
+// by either end, using errors.Is.
+var ErrChannelReset = errors.New("qmux: channel reset")
+
+// ResetError is returned by Read and Write on a channel that was reset.
+type ResetError struct {
+	// Code is the error code the channel was reset with.
+	Code uint32
+	// Remote is set if the channel was reset by the other end.
+	Remote bool
+}
+
+func (e *ResetError) Error() string {
+	if e.Remote {
+		return fmt.Sprintf("qmux: channel reset by remote side with code %d", e.Code)
+	}
+	return fmt.Sprintf("qmux: channel reset with code %d", e.Code)
+}
+
+// Is reports whether target is ErrChannelReset.
+func (e *ResetError) Is(target error) bool {
+	return target == ErrChannelReset
+}
+
+// A Resetter is a Channel that can be aborted, rather than closed, so
+// the other end can tell a channel that was abandoned, such as a cancelled
+// call, from one that completed.
+type Resetter interface {
+	// Reset aborts the channel in both directions with an application
+	// defined error code. Data buffered on either end is discarded and
+	// Read and Write on both ends fail with a ResetError. If the other
+	// end doesn't support resets, it is sent a close instead and sees
+	// the channel closed. It returns io.EOF if the channel was already
+	// closed.
+	Reset(code uint32) error
+}
+
+// Reset aborts the channel, discarding data buffered on both ends.
+func (ch *channel) Reset(code uint32) error {
+	ch.writeMu.Lock()
+	defer ch.writeMu.Unlock()
+	if ch.sentClose {
+		return io.EOF
+	}
+	// the channel is forgotten once the other end replies with a close
+	ch.sentClose = true
+	ch.abort(&ResetError{Code: code})
+	if !ch.session.peerExtended() {
+		// resets are one of the added frame types
+		return ch.session.encodeOn(ch, frame.CloseMessage{
+			ChannelID: ch.remoteId,
+		})
+	}
+	return ch.session.encodeOn(ch, frame.ResetMessage{
+		ChannelID: ch.remoteId,
+		Code:      code,
+	})
+}
+
+// handleReset aborts the channel when the other end resets it, and replies
+// with a close unless one has been sent already.
+func (ch *channel) handleReset(msg *frame.ResetMessage) {
+	ch.abort(&ResetError{Code: msg.Code, Remote: true})
+	ch.send(frame.CloseMessage{
+		ChannelID: ch.remoteId,
+	})
+	ch.session.chans.remove(ch.localId)
+	ch.close()
+}
+
+// abort discards the data buffered for the channel in both directions,
+// failing reads and writes with err.
+func (ch *channel) abort(err error) {
+	ch.pending.reset(err)
+	ch.remoteWin.reset(err)
+	ch.session.sched.discard(ch, err)
+}
+
+// ForwardReset resets chs with the code of err if it is a ResetError, so
+// a reset is passed on through a proxy, and reports whether it did.
+func ForwardReset(err error, chs ...Channel) bool {
+	var reset *ResetError
+	if !errors.As(err, &reset) {
+		return false
+	}
+	for _, ch := range chs {
+		if r, ok := ch.(Resetter); ok {
+			r.Reset(reset.Code)
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// expectReset fails the test unless err is a reset with code.
+func expectReset(t *testing.T, err error, code uint32, remote bool) {
+	t.Helper()
+	if !errors.Is(err, ErrChannelReset) {
+		t.Fatalf("expected ErrChannelReset, but got: %v", err)
+	}
+	var reset *ResetError
+	if !errors.As(err, &reset) || reset.Code != code || reset.Remote != remote {
+		t.Fatalf("unexpected reset error: %v", err)
+	}
+}
+
+func TestChannelReset(t *testing.T) {
+	sessA, sessB := newPipeSessions(t)
+	chA, chB := openPair(t, sessA, sessB)
+
+	_, err := chA.Write([]byte("discarded"))
+	fatal(err, t)
+	for chB.(*channel).pending.len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fatal(chA.(Resetter).Reset(7), t)
+
+	// buffered data is discarded rather than read
+	_, err = chB.Read(make([]byte, 16))
+	expectReset(t, err, 7, true)
+	_, err = chB.Write([]byte("x"))
+	expectReset(t, err, 7, true)
+	_, err = chA.Read(make([]byte, 16))
+	expectReset(t, err, 7, false)
+	_, err = chA.Write([]byte("x"))
+	expectReset(t, err, 7, false)
+	if err := chA.(Resetter).Reset(7); err != io.EOF {
+		t.Fatalf("expected io.EOF resetting twice, but got: %v", err)
+	}
+
+	<-chA.(CloseNotifier).CloseNotify()
+	<-chB.(CloseNotifier).CloseNotify()
+	if n := sessA.(*session).chans.len() + sessB.(*session).chans.len(); n != 0 {
+		t.Fatalf("reset channels were not removed: %d left", n)
+	}
+	if n := sessB.(StatsReporter).Stats().FramesReceived["Reset"].Frames; n != 1 {
+		t.Fatalf("unexpected reset frames received: %d", n)
+	}
+
+	// the session keeps working
+	chA, chB = openPair(t, sessA, sessB)
+	transfer(t, chA, chB, 1024)
+}
+
+func TestChannelResetBlockedWrite(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := NewWithConfig(b, Config{WindowSize: 1024})
+	defer sessA.Close()
+	defer sessB.Close()
+	chA, chB := openPair(t, sessA, sessB)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := chA.Write(make([]byte, 64*1024))
+		written <- err
+	}()
+	chA.(*channel).remoteWin.waitWriterBlocked()
+	fatal(chB.(Resetter).Reset(3), t)
+	expectReset(t, <-written, 3, true)
+
+	// both ending the channel at once is fine
+	chA, chB = openPair(t, sessA, sessB)
+	go chA.(Resetter).Reset(1)
+	chB.(Resetter).Reset(2)
+	<-chA.(CloseNotifier).CloseNotify()
+	<-chB.(CloseNotifier).CloseNotify()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := sessA.(Pinger).Ping(ctx)
+	fatal(err, t)
+}
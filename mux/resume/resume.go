@@ -0,0 +1,468 @@
+// Package resume implements resumable transports for mux sessions, so that
+// sessions and their open channels survive the underlying connection
+// dropping.
+//
+// A resumable connection runs over a series of underlying connections.
+// When one is established, the ends exchange a session token along with how
+// many bytes they have received. Data is sent in sequenced records that the
+// other end acknowledges, and unacknowledged data is buffered so it can be
+// replayed over the next connection. Since the frames of every channel in a
+// session are sent over the same resumable connection, this preserves the
+// data, flow control and state of each channel across reconnects.
+//
+// Both ends have to use this package, since it adds its own handshake and
+// framing to the transport. Use Dial on the dialing end and Listen or a
+// Server on the accepting end.
+package resume
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux"
+)
+
+var (
+	// ErrResumeTimeout is returned when a connection was closed because
+	// it couldn't be resumed within Config.ResumeTimeout.
+	ErrResumeTimeout = errors.New("resume: timed out waiting to resume")
+
+	// ErrUnknownSession is returned when the other end doesn't know the
+	// session being resumed, such as after it timed out.
+	ErrUnknownSession = errors.New("resume: unknown session")
+)
+
+const (
+	// maxRecordLen limits the data sent in one record.
+	maxRecordLen = 1 << 16
+
+	defaultMaxBuffer     = 4 << 20
+	defaultResumeTimeout = 30 * time.Second
+
+	// reconnect backoff bounds for the dialing end
+	minBackoff = 50 * time.Millisecond
+	maxBackoff = 2 * time.Second
+)
+
+// Config holds optional settings for resumable connections.
+type Config struct {
+	// Session holds the settings of the mux sessions run over
+	// resumable connections.
+	Session mux.Config
+
+	// MaxBuffer limits how much unacknowledged data is buffered for replay.
+	// Writes block while the buffer is full. It defaults to 4MB.
+	MaxBuffer int
+
+	// ResumeTimeout is how long a dropped connection waits to be resumed
+	// before it is closed with ErrResumeTimeout. It defaults to 30 seconds.
+	ResumeTimeout time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxBuffer <= 0 {
+		c.MaxBuffer = defaultMaxBuffer
+	}
+	if c.ResumeTimeout == 0 {
+		c.ResumeTimeout = defaultResumeTimeout
+	}
+	return c
+}
+
+// Conn is a resumable connection. It is an io.ReadWriteCloser that keeps
+// working across underlying connections, used as the transport of a session.
+type Conn struct {
+	cfg   Config
+	token token
+
+	// dial redials on the dialing end, and is nil on the accepting end.
+	dial func() (io.ReadWriteCloser, error)
+
+	// onClose is called once the connection is closed.
+	onClose func()
+
+	// writeMu serializes writes to the underlying connection.
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	link    *link // nil while disconnected
+	closed  bool
+	err     error
+	timer   *time.Timer
+	sendBuf []byte // unacknowledged data, starting at acked
+	acked   uint64 // bytes acknowledged by the other end
+	sent    uint64 // bytes written, acked plus len(sendBuf)
+	readBuf []byte // received data not read yet
+	recvd   uint64 // bytes received
+}
+
+// link is one underlying connection of a Conn.
+type link struct {
+	rw   io.ReadWriteCloser
+	ack  chan struct{} // signals that an acknowledgement is due
+	done chan struct{} // closed when the link is detached
+}
+
+func newConn(cfg Config, tok token) *Conn {
+	c := &Conn{cfg: cfg, token: tok}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Dial establishes a resumable connection using dial to make each
+// underlying connection, and returns a session running over it. The
+// connection is redialed whenever it drops until it is resumed, closed,
+// or ResumeTimeout passes.
+func Dial(dial func() (io.ReadWriteCloser, error), cfg Config) (mux.Session, error) {
+	cfg = cfg.withDefaults()
+	rw, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	tok, peerRecvd, err := clientHandshake(rw, token{}, 0)
+	if err != nil {
+		rw.Close()
+		return nil, err
+	}
+	c := newConn(cfg, tok)
+	c.dial = dial
+	if _, err := c.attach(rw, peerRecvd); err != nil {
+		return nil, err
+	}
+	return mux.NewWithConfig(c, cfg.Session), nil
+}
+
+// Read reads data received from the other end.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.readBuf) == 0 {
+		if c.closed {
+			return 0, c.err
+		}
+		c.cond.Wait()
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	if len(c.readBuf) == 0 {
+		c.readBuf = nil
+	}
+	return n, nil
+}
+
+// Write buffers p until it is acknowledged and sends it over the current
+// connection, if any. It blocks while the replay buffer is full.
+func (c *Conn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		c.mu.Lock()
+		for !c.closed && len(c.sendBuf) >= c.cfg.MaxBuffer {
+			c.cond.Wait()
+		}
+		c.mu.Unlock()
+
+		c.writeMu.Lock()
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			c.writeMu.Unlock()
+			return written, c.err
+		}
+		n := c.cfg.MaxBuffer - len(c.sendBuf)
+		if n > len(p) {
+			n = len(p)
+		}
+		if n > maxRecordLen {
+			n = maxRecordLen
+		}
+		if n <= 0 {
+			// another write filled the buffer first
+			c.mu.Unlock()
+			c.writeMu.Unlock()
+			continue
+		}
+		c.sendBuf = append(c.sendBuf, p[:n]...)
+		c.sent += uint64(n)
+		l := c.link
+		c.mu.Unlock()
+
+		if l != nil {
+			if err := writeData(l.rw, p[:n]); err != nil {
+				c.detach(l)
+			}
+		}
+		c.writeMu.Unlock()
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close closes the connection, telling the other end not to wait for
+// it to be resumed. Data not yet acknowledged may be lost.
+func (c *Conn) Close() error {
+	if c.writeMu.TryLock() {
+		c.mu.Lock()
+		l := c.link
+		c.mu.Unlock()
+		if l != nil {
+			writeRecord(l.rw, recordClose, nil)
+		}
+		c.writeMu.Unlock()
+	}
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+// fail closes the connection, making err the error returned by reads and writes.
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.err = err
+	if c.link != nil {
+		close(c.link.done)
+		c.link.rw.Close()
+		c.link = nil
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	if c.onClose != nil {
+		c.onClose()
+	}
+}
+
+// suspend detaches the current link, if any, so that nothing more is
+// received from it, and returns how many bytes have been received.
+func (c *Conn) suspend() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, c.err
+	}
+	if c.link != nil {
+		close(c.link.done)
+		c.link.rw.Close()
+		c.link = nil
+	}
+	return c.recvd, nil
+}
+
+// attach makes rw the current link once the handshake is done, replaying
+// whatever the other end hasn't received according to peerRecvd.
+func (c *Conn) attach(rw io.ReadWriteCloser, peerRecvd uint64) (*link, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		rw.Close()
+		return nil, c.err
+	}
+	if peerRecvd < c.acked || peerRecvd > c.sent {
+		c.mu.Unlock()
+		rw.Close()
+		err := fmt.Errorf("resume: other end received %d bytes, but %d were sent and %d acknowledged", peerRecvd, c.sent, c.acked)
+		c.fail(err)
+		return nil, err
+	}
+	c.sendBuf = c.sendBuf[peerRecvd-c.acked:]
+	c.acked = peerRecvd
+	replay := append([]byte(nil), c.sendBuf...)
+	l := &link{
+		rw:   rw,
+		ack:  make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	if c.link != nil {
+		close(c.link.done)
+		c.link.rw.Close()
+	}
+	c.link = l
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	go c.readLoop(l)
+	go c.ackLoop(l)
+
+	for len(replay) > 0 {
+		n := len(replay)
+		if n > maxRecordLen {
+			n = maxRecordLen
+		}
+		if err := writeData(rw, replay[:n]); err != nil {
+			c.detach(l)
+			return l, nil
+		}
+		replay = replay[n:]
+	}
+	return l, nil
+}
+
+// detach drops a link after it failed, and starts resuming the connection.
+func (c *Conn) detach(l *link) {
+	c.mu.Lock()
+	if c.link != l {
+		c.mu.Unlock()
+		return
+	}
+	close(l.done)
+	l.rw.Close()
+	c.link = nil
+	c.mu.Unlock()
+	c.disconnected()
+}
+
+// disconnected starts resuming the connection after losing its link. The
+// dialing end redials, and the accepting end waits for the other end to.
+func (c *Conn) disconnected() {
+	if c.dial != nil {
+		go c.redial()
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.link != nil {
+		return
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timer = time.AfterFunc(c.cfg.ResumeTimeout, func() {
+		c.mu.Lock()
+		resumed := c.link != nil
+		c.mu.Unlock()
+		if !resumed {
+			c.fail(ErrResumeTimeout)
+		}
+	})
+}
+
+// redial reconnects on the dialing end until the connection is resumed,
+// or fails with ErrResumeTimeout.
+func (c *Conn) redial() {
+	deadline := time.Now().Add(c.cfg.ResumeTimeout)
+	backoff := minBackoff
+	for {
+		recvd, err := c.suspend()
+		if err != nil {
+			return
+		}
+		rw, err := c.dial()
+		if err == nil {
+			var peerRecvd uint64
+			_, peerRecvd, err = clientHandshake(rw, c.token, recvd)
+			if err == nil {
+				c.attach(rw, peerRecvd)
+				return
+			}
+			rw.Close()
+			if errors.Is(err, ErrUnknownSession) {
+				c.fail(err)
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			c.fail(ErrResumeTimeout)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// readLoop reads records from a link until it fails or is detached.
+func (c *Conn) readLoop(l *link) {
+	r := bufio.NewReader(l.rw)
+	buf := make([]byte, maxRecordLen)
+	for {
+		kind, payload, err := readRecord(r, buf)
+		if err != nil {
+			c.detach(l)
+			return
+		}
+		switch kind {
+		case recordData:
+			c.mu.Lock()
+			if c.link != l {
+				c.mu.Unlock()
+				return
+			}
+			c.readBuf = append(c.readBuf, payload...)
+			c.recvd += uint64(len(payload))
+			c.cond.Broadcast()
+			c.mu.Unlock()
+			select {
+			case l.ack <- struct{}{}:
+			default:
+			}
+		case recordAck:
+			seq := binary.BigEndian.Uint64(payload)
+			c.mu.Lock()
+			if c.link == l && seq > c.acked && seq <= c.sent {
+				c.sendBuf = c.sendBuf[seq-c.acked:]
+				c.acked = seq
+				c.cond.Broadcast()
+			}
+			c.mu.Unlock()
+		case recordClose:
+			c.fail(io.EOF)
+			return
+		}
+	}
+}
+
+// ackLoop acknowledges received data on a link. Acknowledgements are
+// sent from their own goroutine so that reading never waits on writing.
+func (c *Conn) ackLoop(l *link) {
+	var seq [8]byte
+	for {
+		select {
+		case <-l.ack:
+		case <-l.done:
+			return
+		}
+		c.mu.Lock()
+		binary.BigEndian.PutUint64(seq[:], c.recvd)
+		c.mu.Unlock()
+
+		c.writeMu.Lock()
+		err := writeRecord(l.rw, recordAck, seq[:])
+		c.writeMu.Unlock()
+		if err != nil {
+			c.detach(l)
+			return
+		}
+	}
+}
+
+// token identifies a resumable connection.
+type token [16]byte
+
+func newToken() (token, error) {
+	var t token
+	_, err := rand.Read(t[:])
+	return t, err
+}
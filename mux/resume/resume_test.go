@@ -0,0 +1,199 @@
+package resume
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux"
+)
+
+func fatal(err error, t *testing.T) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flakyNet connects clients to a Server over in-memory pipes
+// that can be dropped on purpose.
+type flakyNet struct {
+	srv *Server
+
+	mu      sync.Mutex
+	conns   []net.Conn
+	refuse  bool
+	dropped int
+}
+
+func (n *flakyNet) dial() (io.ReadWriteCloser, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.refuse {
+		return nil, errors.New("connection refused")
+	}
+	a, b := net.Pipe()
+	go n.srv.Serve(b)
+	n.conns = append(n.conns, a, b)
+	return a, nil
+}
+
+// drop closes every connection made so far.
+func (n *flakyNet) drop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, conn := range n.conns {
+		conn.Close()
+	}
+	n.conns = nil
+	n.dropped++
+}
+
+func (n *flakyNet) setRefuse(refuse bool) {
+	n.mu.Lock()
+	n.refuse = refuse
+	n.mu.Unlock()
+}
+
+func newFlakyPair(t *testing.T, cfg Config) (*flakyNet, mux.Session, mux.Session) {
+	t.Helper()
+	n := &flakyNet{srv: NewServer(cfg)}
+	t.Cleanup(func() { n.srv.Close() })
+
+	client, err := Dial(n.dial, cfg)
+	fatal(err, t)
+	t.Cleanup(func() { client.Close() })
+	server, err := n.srv.Accept()
+	fatal(err, t)
+	t.Cleanup(func() { server.Close() })
+	return n, client, server
+}
+
+func TestResume(t *testing.T) {
+	n, client, server := newFlakyPair(t, Config{MaxBuffer: 1 << 16})
+
+	data := make([]byte, 4<<20)
+	_, err := rand.Read(data)
+	fatal(err, t)
+
+	// stream data both ways while dropping connections
+	var wg sync.WaitGroup
+	stream := func(ch mux.Channel, who string) {
+		defer wg.Done()
+		written := make(chan error, 1)
+		go func() {
+			_, err := ch.Write(data)
+			if err == nil {
+				err = ch.CloseWrite()
+			}
+			written <- err
+		}()
+		b, err := io.ReadAll(ch)
+		if err != nil {
+			t.Error(who, "read error:", err)
+		} else if !bytes.Equal(b, data) {
+			t.Error(who, "received corrupted data")
+		}
+		if err := <-written; err != nil {
+			t.Error(who, "write error:", err)
+		}
+	}
+
+	wg.Add(2)
+	go func() {
+		ch, err := client.Open(context.Background())
+		if err != nil {
+			t.Error(err)
+			wg.Done()
+			return
+		}
+		stream(ch, "client")
+	}()
+
+	stop := make(chan struct{})
+	dropped := make(chan struct{})
+	go func() {
+		defer close(dropped)
+		for i := 0; i < 5; i++ {
+			select {
+			case <-time.After(5 * time.Millisecond):
+				n.drop()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	ch, err := server.Accept()
+	fatal(err, t)
+	stream(ch, "server")
+	wg.Wait()
+	close(stop)
+	<-dropped
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	n.mu.Lock()
+	drops := n.dropped
+	n.mu.Unlock()
+	if drops == 0 {
+		t.Fatal("no connections dropped during transfer")
+	}
+
+	// the session keeps working after the drops
+	go func() {
+		ch, err := client.Open(context.Background())
+		fatal(err, t)
+		ch.Write([]byte("still here"))
+		ch.Close()
+	}()
+	ch, err = server.Accept()
+	fatal(err, t)
+	b, err := io.ReadAll(ch)
+	fatal(err, t)
+	if string(b) != "still here" {
+		t.Fatalf("unexpected data: %q", b)
+	}
+}
+
+func TestResumeTimeout(t *testing.T) {
+	n, client, server := newFlakyPair(t, Config{ResumeTimeout: 50 * time.Millisecond})
+
+	n.setRefuse(true)
+	n.drop()
+
+	if err := client.Wait(); err != ErrResumeTimeout {
+		t.Fatal("unexpected client error:", err)
+	}
+	if err := server.Wait(); err != ErrResumeTimeout {
+		t.Fatal("unexpected server error:", err)
+	}
+}
+
+func TestResumeClose(t *testing.T) {
+	_, client, server := newFlakyPair(t, Config{})
+
+	client.Close()
+	if err := server.Wait(); err != io.EOF {
+		t.Fatal("unexpected server error:", err)
+	}
+}
+
+func TestResumeUnknownSession(t *testing.T) {
+	n := &flakyNet{srv: NewServer(Config{})}
+	defer n.srv.Close()
+
+	rw, err := n.dial()
+	fatal(err, t)
+	defer rw.Close()
+	if _, _, err := clientHandshake(rw, token{1}, 0); err != ErrUnknownSession {
+		t.Fatal("unexpected handshake error:", err)
+	}
+}
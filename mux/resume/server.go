@@ -0,0 +1,178 @@
+package resume
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/progrium/qtalk-go/mux"
+)
+
+// Server is the accepting end of resumable connections. Underlying
+// connections are passed to Serve, which either starts a new session to be
+// returned by Accept or resumes the session they belong to. Server is a
+// mux.Listener.
+type Server struct {
+	cfg Config
+
+	mu       sync.Mutex
+	conns    map[token]*Conn
+	accepted chan mux.Session
+	done     chan struct{}
+	closed   bool
+}
+
+// NewServer returns a Server for resumable connections with the settings in cfg.
+func NewServer(cfg Config) *Server {
+	return &Server{
+		cfg:      cfg.withDefaults(),
+		conns:    make(map[token]*Conn),
+		accepted: make(chan mux.Session),
+		done:     make(chan struct{}),
+	}
+}
+
+// Serve runs the handshake over rw and uses it for the connection it
+// starts or resumes. It blocks until rw is no longer used, so it can
+// be called from handlers that close their connection on return, such
+// as WebSocket handlers.
+func (s *Server) Serve(rw io.ReadWriteCloser) error {
+	tok, peerRecvd, err := readHello(rw)
+	if err != nil {
+		rw.Close()
+		return err
+	}
+
+	var zero token
+	if tok == zero {
+		return s.serveNew(rw)
+	}
+
+	s.mu.Lock()
+	c := s.conns[tok]
+	s.mu.Unlock()
+	if c == nil {
+		writeReply(rw, handshakeUnknown, tok, 0)
+		rw.Close()
+		return ErrUnknownSession
+	}
+	recvd, err := c.suspend()
+	if err != nil {
+		writeReply(rw, handshakeUnknown, tok, 0)
+		rw.Close()
+		return ErrUnknownSession
+	}
+	if err := writeReply(rw, handshakeOK, tok, recvd); err != nil {
+		rw.Close()
+		c.disconnected()
+		return err
+	}
+	return s.wait(c, rw, peerRecvd)
+}
+
+func (s *Server) serveNew(rw io.ReadWriteCloser) error {
+	tok, err := newToken()
+	if err != nil {
+		rw.Close()
+		return err
+	}
+	c := newConn(s.cfg, tok)
+	c.onClose = func() {
+		s.mu.Lock()
+		delete(s.conns, tok)
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		rw.Close()
+		return net.ErrClosed
+	}
+	s.conns[tok] = c
+	s.mu.Unlock()
+
+	if err := writeReply(rw, handshakeOK, tok, 0); err != nil {
+		c.fail(err)
+		rw.Close()
+		return err
+	}
+
+	sess := mux.NewWithConfig(c, s.cfg.Session)
+	go func() {
+		select {
+		case s.accepted <- sess:
+		case <-s.done:
+			sess.Close()
+		}
+	}()
+	return s.wait(c, rw, 0)
+}
+
+// wait attaches rw to c and waits until it is detached.
+func (s *Server) wait(c *Conn, rw io.ReadWriteCloser, peerRecvd uint64) error {
+	l, err := c.attach(rw, peerRecvd)
+	if err != nil {
+		return err
+	}
+	<-l.done
+	return nil
+}
+
+// Accept waits for and returns the next new session.
+func (s *Server) Accept() (mux.Session, error) {
+	select {
+	case sess := <-s.accepted:
+		return sess, nil
+	case <-s.done:
+		return nil, io.EOF
+	}
+}
+
+// Close stops accepting new sessions. Sessions already accepted
+// keep running and can still be resumed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	return nil
+}
+
+// Addr returns nil since a Server isn't bound to an address.
+func (s *Server) Addr() net.Addr {
+	return nil
+}
+
+// netListener serves connections accepted from a net.Listener.
+type netListener struct {
+	*Server
+	l net.Listener
+}
+
+// Listen returns a mux.Listener that serves resumable connections
+// accepted from l.
+func Listen(l net.Listener, cfg Config) mux.Listener {
+	nl := &netListener{Server: NewServer(cfg), l: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				nl.Server.Close()
+				return
+			}
+			go nl.Serve(conn)
+		}
+	}()
+	return nl
+}
+
+func (l *netListener) Close() error {
+	l.Server.Close()
+	return l.l.Close()
+}
+
+func (l *netListener) Addr() net.Addr {
+	return l.l.Addr()
+}
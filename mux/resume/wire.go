@@ -0,0 +1,118 @@
+package resume
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	recordData  byte = 0
+	recordAck   byte = 1
+	recordClose byte = 2
+
+	handshakeOK      byte = 0
+	handshakeUnknown byte = 1
+
+	version = 1
+)
+
+var magic = [4]byte{'q', 'r', 'e', 's'}
+
+// clientHandshake sends the token of the connection being resumed, or a
+// zero token for a new one, along with how many bytes have been received.
+// It returns the token and how many bytes the other end has received.
+func clientHandshake(rw io.ReadWriter, tok token, recvd uint64) (token, uint64, error) {
+	var hello [29]byte
+	copy(hello[:], magic[:])
+	hello[4] = version
+	copy(hello[5:], tok[:])
+	binary.BigEndian.PutUint64(hello[21:], recvd)
+	if _, err := rw.Write(hello[:]); err != nil {
+		return token{}, 0, err
+	}
+
+	var reply [25]byte
+	if _, err := io.ReadFull(rw, reply[:]); err != nil {
+		return token{}, 0, err
+	}
+	if reply[0] == handshakeUnknown {
+		return token{}, 0, ErrUnknownSession
+	}
+	if reply[0] != handshakeOK {
+		return token{}, 0, fmt.Errorf("resume: unexpected handshake status %d", reply[0])
+	}
+	copy(tok[:], reply[1:17])
+	return tok, binary.BigEndian.Uint64(reply[17:]), nil
+}
+
+// readHello reads the token and received byte count sent by clientHandshake.
+func readHello(r io.Reader) (token, uint64, error) {
+	var hello [29]byte
+	if _, err := io.ReadFull(r, hello[:]); err != nil {
+		return token{}, 0, err
+	}
+	if !bytes.Equal(hello[:4], magic[:]) || hello[4] != version {
+		return token{}, 0, fmt.Errorf("resume: unexpected handshake %x", hello[:5])
+	}
+	var tok token
+	copy(tok[:], hello[5:21])
+	return tok, binary.BigEndian.Uint64(hello[21:]), nil
+}
+
+// writeReply answers clientHandshake.
+func writeReply(w io.Writer, status byte, tok token, recvd uint64) error {
+	var reply [25]byte
+	reply[0] = status
+	copy(reply[1:], tok[:])
+	binary.BigEndian.PutUint64(reply[17:], recvd)
+	_, err := w.Write(reply[:])
+	return err
+}
+
+// readRecord reads a record, reading its payload into buf.
+func readRecord(r io.Reader, buf []byte) (byte, []byte, error) {
+	var kind [1]byte
+	if _, err := io.ReadFull(r, kind[:]); err != nil {
+		return 0, nil, err
+	}
+	switch kind[0] {
+	case recordData:
+		var length [4]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return 0, nil, err
+		}
+		n := binary.BigEndian.Uint32(length[:])
+		if n > maxRecordLen {
+			return 0, nil, fmt.Errorf("resume: record too long: %d bytes", n)
+		}
+		_, err := io.ReadFull(r, buf[:n])
+		return recordData, buf[:n], err
+	case recordAck:
+		_, err := io.ReadFull(r, buf[:8])
+		return recordAck, buf[:8], err
+	case recordClose:
+		return recordClose, nil, nil
+	default:
+		return 0, nil, fmt.Errorf("resume: unknown record type %d", kind[0])
+	}
+}
+
+// writeData writes a data record.
+func writeData(w io.Writer, p []byte) error {
+	return writeRecord(w, recordData, p)
+}
+
+// writeRecord writes a record with its payload in a single write.
+func writeRecord(w io.Writer, kind byte, payload []byte) error {
+	buf := make([]byte, 1, 5+len(payload))
+	buf[0] = kind
+	if kind == recordData {
+		buf = buf[:5]
+		binary.BigEndian.PutUint32(buf[1:], uint32(len(payload)))
+	}
+	buf = append(buf, payload...)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,290 @@
+package mux
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+const (
+	// DefaultPriority is the priority channels start with.
+	DefaultPriority = 1
+	// MaxPriority is the highest priority a channel can be given.
+	MaxPriority = 256
+
+	// defaultWriteChunkSize is the default Config.WriteChunkSize.
+	defaultWriteChunkSize = 32 << 10
+)
+
+// A Prioritizer is a Channel whose share of the session's writes
+// can be weighted against other channels.
+type Prioritizer interface {
+	// SetPriority sets the weight of the channel while it competes with
+	// other channels to send data. While both have data waiting, a channel
+	// with priority 4 sends four times as much as one with priority 1.
+	// Values are clamped to between 1 and MaxPriority.
+	SetPriority(priority int)
+}
+
+// SetPriority sets the weight of the channel's writes against other channels.
+func (ch *channel) SetPriority(priority int) {
+	if priority < 1 {
+		priority = 1
+	}
+	if priority > MaxPriority {
+		priority = MaxPriority
+	}
+	atomic.StoreInt32(&ch.priority, int32(priority))
+}
+
+// writeRequest is a frame waiting to be written by the scheduler.
+type writeRequest struct {
+	msg  frame.Message
+	size int
+	err  chan error
+}
+
+// writeRequests recycles write requests along with their error channels.
+var writeRequests = sync.Pool{
+	New: func() any {
+		return &writeRequest{err: make(chan error, 1)}
+	},
+}
+
+// writeQueue holds the data frames a channel is waiting to write.
+type writeQueue struct {
+	reqs []*writeRequest
+	// vtime is the virtual time the channel's next frame starts at. It
+	// advances by the size of each frame written, scaled down by priority.
+	vtime uint64
+	// closed is set once a close has been queued or the channel has
+	// been reset, after which data can't be sent.
+	closed bool
+}
+
+// scheduler orders the frames written to a session's transport. Control
+// frames, such as opens, window adjusts and closes, are written first in
+// the order they were queued. Data frames are written by weighted fair
+// queueing across channels, so a bulk transfer can't starve the others.
+//
+// A writer that finds the transport idle writes its own frame without a
+// context switch. Otherwise, its frame waits for the current owner of the
+// transport, which hands the queue over to the session's write loop once
+// its own frame has been written.
+type scheduler struct {
+	mu      sync.Mutex
+	control []*writeRequest
+	active  []*channel // channels with queued data frames
+	vclock  uint64     // vtime of the last data frame written
+
+	busy   bool          // set while a writer owns the transport
+	closed bool          // set once the write loop has exited
+	wake   chan struct{} // hands ownership to the write loop
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{wake: make(chan struct{}, 1)}
+}
+
+// push queues a frame, on the data queue of ch if it is set. Other frames
+// of ch are only queued there behind data that is still waiting, so they
+// don't overtake it. Data queued after a close fails with io.EOF, since
+// the other end may already have forgotten the channel. It returns true
+// if the transport was idle, making the caller its owner.
+func (sc *scheduler) push(ch *channel, req *writeRequest) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	_, isData := req.msg.(frame.DataMessage)
+	if ch != nil {
+		if isData && ch.queue.closed {
+			req.err <- io.EOF
+			return false
+		}
+		if _, ok := req.msg.(frame.CloseMessage); ok {
+			ch.queue.closed = true
+		}
+	}
+	if ch == nil || (!isData && len(ch.queue.reqs) == 0) {
+		sc.control = append(sc.control, req)
+	} else {
+		if len(ch.queue.reqs) == 0 {
+			// a channel that was idle doesn't get credit for the time it
+			// wasn't sending
+			if ch.queue.vtime < sc.vclock {
+				ch.queue.vtime = sc.vclock
+			}
+			sc.active = append(sc.active, ch)
+		}
+		ch.queue.reqs = append(ch.queue.reqs, req)
+	}
+	own := !sc.busy
+	sc.busy = true
+	return own
+}
+
+// discard fails the data frames ch has queued with err, so they are never
+// written, and keeps it from queueing more. Its other frames stay queued.
+func (sc *scheduler) discard(ch *channel, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	q := &ch.queue
+	q.closed = true
+	if len(q.reqs) == 0 {
+		return
+	}
+	reqs := q.reqs[:0]
+	for _, req := range q.reqs {
+		if _, isData := req.msg.(frame.DataMessage); isData {
+			req.err <- err
+			continue
+		}
+		reqs = append(reqs, req)
+	}
+	for i := len(reqs); i < len(q.reqs); i++ {
+		q.reqs[i] = nil
+	}
+	q.reqs = reqs
+	if len(reqs) > 0 {
+		return
+	}
+	q.reqs = nil
+	for i, active := range sc.active {
+		if active == ch {
+			last := len(sc.active) - 1
+			sc.active[i] = sc.active[last]
+			sc.active[last] = nil
+			sc.active = sc.active[:last]
+			break
+		}
+	}
+}
+
+// next returns the next frame for the owner to write. If none are
+// queued, it releases ownership and returns nil.
+func (sc *scheduler) next() *writeRequest {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	req := sc.pop()
+	if req == nil {
+		sc.busy = false
+	}
+	return req
+}
+
+// handoff releases ownership if no frames are queued, or otherwise passes
+// it to the write loop. It returns false if the write loop has exited, in
+// which case the caller keeps ownership.
+func (sc *scheduler) handoff() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.control) == 0 && len(sc.active) == 0 {
+		sc.busy = false
+		return true
+	}
+	if sc.closed {
+		return false
+	}
+	sc.wake <- struct{}{}
+	return true
+}
+
+// stop marks the write loop exited, returning true if ownership was
+// handed to it first.
+func (sc *scheduler) stop() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.closed = true
+	select {
+	case <-sc.wake:
+		return true
+	default:
+		return false
+	}
+}
+
+// pop returns the next queued frame, or nil if none are queued.
+// It must be called with sc.mu held.
+func (sc *scheduler) pop() *writeRequest {
+	if len(sc.control) > 0 {
+		req := sc.control[0]
+		sc.control[0] = nil
+		sc.control = sc.control[1:]
+		return req
+	}
+	if len(sc.active) == 0 {
+		return nil
+	}
+
+	next := 0
+	for i, ch := range sc.active {
+		if ch.queue.vtime < sc.active[next].queue.vtime {
+			next = i
+		}
+	}
+	ch := sc.active[next]
+	q := &ch.queue
+	req := q.reqs[0]
+	q.reqs[0] = nil
+	q.reqs = q.reqs[1:]
+
+	sc.vclock = q.vtime
+	q.vtime += uint64(req.size) * MaxPriority / uint64(atomic.LoadInt32(&ch.priority))
+	if len(q.reqs) == 0 {
+		q.reqs = nil
+		last := len(sc.active) - 1
+		sc.active[next] = sc.active[last]
+		sc.active[last] = nil
+		sc.active = sc.active[:last]
+	}
+	return req
+}
+
+// writeLoop writes queued frames whenever ownership of the transport is
+// handed to it, until the session ends.
+func (s *session) writeLoop() {
+	for {
+		select {
+		case <-s.sched.wake:
+			s.flush(nil)
+		case <-s.done:
+			if s.sched.stop() {
+				s.flush(nil)
+			}
+			return
+		}
+	}
+}
+
+// flush writes queued frames while the caller owns the transport, until
+// none are left or until has been written and the rest handed off.
+func (s *session) flush(until *writeRequest) {
+	for {
+		req := s.sched.next()
+		if req == nil {
+			return
+		}
+		if s.cfg.Capture != nil {
+			s.cfg.Capture.CaptureFrame(true, req.msg)
+		}
+		req.err <- s.enc.Encode(req.msg)
+		if req == until && s.sched.handoff() {
+			return
+		}
+	}
+}
+
+// write queues a message to be written by the scheduler and waits until it
+// has been written. Messages of ch are kept in order on its data queue.
+func (s *session) write(ch *channel, msg frame.Message) error {
+	req := writeRequests.Get().(*writeRequest)
+	req.msg, req.size = msg, frameSize(msg)
+	if s.sched.push(ch, req) {
+		s.flush(req)
+	}
+	err := <-req.err
+	req.msg = nil
+	writeRequests.Put(req)
+	return err
+}
@@ -0,0 +1,178 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+func TestScheduler(t *testing.T) {
+	sc := newScheduler()
+	bulk := &channel{priority: DefaultPriority}
+	rpc := &channel{priority: DefaultPriority}
+	rpc.SetPriority(3)
+
+	owner := map[*writeRequest]*channel{}
+	data := func(ch *channel) *writeRequest {
+		req := &writeRequest{msg: frame.DataMessage{}, size: 1024}
+		owner[req] = ch
+		return req
+	}
+	for i := 0; i < 400; i++ {
+		sc.push(bulk, data(bulk))
+		sc.push(rpc, data(rpc))
+	}
+	ctrl := &writeRequest{msg: frame.WindowAdjustMessage{}}
+	sc.push(nil, ctrl)
+
+	if req := sc.next(); req != ctrl {
+		t.Fatal("control frame was not written first")
+	}
+
+	sent := map[*channel]int{}
+	for i := 0; i < 400; i++ {
+		req := sc.next()
+		if req == nil {
+			t.Fatal("ran out of frames")
+		}
+		sent[owner[req]]++
+	}
+	if sent[bulk] != 100 || sent[rpc] != 300 {
+		t.Fatalf("unexpected share of writes: bulk=%d rpc=%d", sent[bulk], sent[rpc])
+	}
+
+	// a channel that was idle doesn't get to catch up on its share
+	idle := &channel{priority: DefaultPriority}
+	for i := 0; i < 3; i++ {
+		sc.push(idle, data(idle))
+	}
+	var order []*channel
+	for i := 0; i < 3; i++ {
+		order = append(order, owner[sc.next()])
+	}
+	if order[0] != idle || order[1] == idle {
+		t.Fatal("idle channel was not interleaved with the others")
+	}
+}
+
+func TestSchedulerDiscard(t *testing.T) {
+	sc := newScheduler()
+	ch := &channel{priority: DefaultPriority}
+	data := &writeRequest{msg: frame.DataMessage{}, size: 1024, err: make(chan error, 1)}
+	eof := &writeRequest{msg: frame.EOFMessage{}, err: make(chan error, 1)}
+	sc.push(ch, data)
+	sc.push(ch, eof)
+
+	errReset := &ResetError{Code: 1}
+	sc.discard(ch, errReset)
+	if err := <-data.err; err != errReset {
+		t.Fatalf("expected queued data to fail with the reset, but got: %v", err)
+	}
+	if req := sc.next(); req != eof {
+		t.Fatal("frame other than data was discarded")
+	}
+	if req := sc.next(); req != nil {
+		t.Fatal("unexpected frame left queued")
+	}
+
+	more := &writeRequest{msg: frame.DataMessage{}, size: 1024, err: make(chan error, 1)}
+	sc.push(ch, more)
+	if err := <-more.err; err != io.EOF {
+		t.Fatalf("expected data queued after discard to fail, but got: %v", err)
+	}
+}
+
+func TestWriteChunking(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := NewWithConfig(a, Config{WriteChunkSize: 1024})
+	sessB := New(b)
+	defer sessA.Close()
+	defer sessB.Close()
+	chA, chB := openPair(t, sessA, sessB)
+
+	go func() {
+		_, err := chA.Write(make([]byte, 10*1024))
+		fatal(err, t)
+		chA.Close()
+	}()
+	n, err := io.Copy(io.Discard, chB)
+	fatal(err, t)
+	if n != 10*1024 {
+		t.Fatalf("unexpected bytes read: %d", n)
+	}
+	if sent := sessA.(StatsReporter).Stats().FramesSent["Data"].Frames; sent != 10 {
+		t.Fatalf("unexpected data frames sent: %d", sent)
+	}
+}
+
+// BenchmarkLatencyUnderBulk measures the round-trip latency of small
+// messages on one channel while another channel streams bulk data over
+// the same session.
+func BenchmarkLatencyUnderBulk(b *testing.B) {
+	for _, bm := range []struct {
+		name  string
+		chunk uint32
+	}{
+		{"chunked", defaultWriteChunkSize},
+		{"unchunked", channelMaxPacket},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			a, c := net.Pipe()
+			sessA := NewWithConfig(a, Config{WriteChunkSize: bm.chunk})
+			sessB := New(c)
+			defer sessA.Close()
+			defer sessB.Close()
+
+			open := func() (Channel, Channel) {
+				accepted := make(chan Channel, 1)
+				go func() {
+					ch, _ := sessB.Accept()
+					accepted <- ch
+				}()
+				ch, err := sessA.Open(context.Background())
+				if err != nil {
+					b.Fatal(err)
+				}
+				return ch, <-accepted
+			}
+
+			bulkA, bulkB := open()
+			go io.Copy(io.Discard, bulkB)
+			go func() {
+				buf := make([]byte, channelMaxPacket)
+				for {
+					if _, err := bulkA.Write(buf); err != nil {
+						return
+					}
+				}
+			}()
+
+			rpcA, rpcB := open()
+			go io.Copy(rpcB, rpcB)
+
+			msg := make([]byte, 64)
+			latencies := make([]time.Duration, 0, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				if _, err := rpcA.Write(msg); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(rpcA, msg); err != nil {
+					b.Fatal(err)
+				}
+				latencies = append(latencies, time.Since(start))
+			}
+			b.StopTimer()
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(latencies[len(latencies)/2].Microseconds()), "p50-µs")
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs")
+		})
+	}
+}
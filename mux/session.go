@@ -1,14 +1,20 @@
 package mux
 
 import (
+	"bufio"
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/progrium/qtalk-go/mux/frame"
+	"github.com/progrium/qtalk-go/observe"
 )
 
 const (
@@ -25,14 +31,40 @@ const (
 	// primarily for testing: setting chanSize=0 uncovers deadlocks more
 	// quickly.
 	chanSize = 16
+
+	// acceptBacklog is the default number of incoming channels
+	// that may wait to be accepted.
+	acceptBacklog = 16
+
+	// defaultWriteBatchSize is the default Config.WriteBatchSize.
+	defaultWriteBatchSize = 64 << 10
+
+	// readBufferSize is the size of the buffer frames are read through.
+	// Only data frames that fit in it can be read into a waiting Read.
+	readBufferSize = 64 << 10
 )
 
 var (
 	// timeout for queuing a new channel to be `Accept`ed
 	// use a `var` so that this can be overridden in tests
 	openTimeout = 30 * time.Second
+
+	// timeout for the authentication handshake of a new session
+	authTimeout = 10 * time.Second
 )
 
+// ErrKeepAliveTimeout is returned by Wait when a session was closed
+// because the other end stopped answering keepalive pings.
+var ErrKeepAliveTimeout = errors.New("qmux: keepalive timeout")
+
+// ErrGoAway is returned by Open when either end of the session
+// has started a graceful shutdown.
+var ErrGoAway = errors.New("qmux: session is shutting down")
+
+// ErrWindowExhausted is returned by Open when the total window of the
+// channels in the session has reached Config.MaxSessionWindow.
+var ErrWindowExhausted = errors.New("qmux: session window exhausted")
+
 // Session is a bi-directional channel muxing session on a given transport.
 type Session interface {
 	io.Closer
@@ -41,39 +73,389 @@ type Session interface {
 	Wait() error
 }
 
+// A Pinger is a Session that can measure the round-trip time
+// to the other end of the session.
+type Pinger interface {
+	Ping(ctx context.Context) (time.Duration, error)
+}
+
+// A Shutdowner is a Session that can be shut down gracefully.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// A TypedSession is a Session that supports typed channel opens, similar
+// to SSH channel types. The type and extra data are sent with the open, so
+// different kinds of channels can share a session without each having to
+// send its own header after the channel is accepted.
+type TypedSession interface {
+	Session
+
+	// OpenWithType establishes a new channel of the given type with the
+	// other end, passing extra data along with the open.
+	OpenWithType(ctx context.Context, kind string, extra []byte) (Channel, error)
+
+	// AcceptWithType waits for and returns the next incoming channel that
+	// has no registered handler, along with its type and extra data.
+	// Anonymous channels have an empty type.
+	AcceptWithType() (ch Channel, kind string, extra []byte, err error)
+
+	// HandleChannelType registers a handler for incoming channels of the
+	// given type. Channels with a registered handler are confirmed right away
+	// and passed to the handler in a new goroutine instead of being queued for
+	// Accept. A nil handler removes the registration.
+	HandleChannelType(kind string, handler ChannelHandler)
+}
+
+// A ChannelHandler handles an incoming channel of a registered type.
+type ChannelHandler func(ch Channel, extra []byte)
+
+// A Requester is a Session that supports session-level global requests,
+// which carry out-of-band control messages that aren't tied to a channel.
+type Requester interface {
+	// SendRequest sends a global request to the other end. If wantReply is
+	// true, it waits for the reply and returns whether the request succeeded
+	// along with the reply payload. Otherwise it returns as soon as the request
+	// is sent.
+	SendRequest(ctx context.Context, name string, wantReply bool, payload []byte) (bool, []byte, error)
+
+	// HandleRequest registers a handler for incoming global requests with the
+	// given name. Requests without a registered handler fail. A nil handler
+	// removes the registration.
+	HandleRequest(name string, handler RequestHandler)
+}
+
+// A RequestHandler handles an incoming global request and returns the reply
+// payload. Returning an error fails the request, using the error message as
+// the reply payload. Each request is handled in its own goroutine.
+type RequestHandler func(payload []byte) ([]byte, error)
+
+// Config holds optional settings for a session. The zero value of each
+// field selects its default.
+type Config struct {
+	// MaxPacketSize is the largest data payload the other end may send
+	// in a single frame on a channel. It defaults to 16MB and is limited
+	// to 2GB.
+	MaxPacketSize uint32
+
+	// WindowSize is the initial flow-control window of each channel, which
+	// limits how much unread data the other end may send. It defaults to 64
+	// times MaxPacketSize, following OpenSSH.
+	WindowSize uint32
+
+	// MaxWindowSize enables window auto-tuning when it is larger than
+	// WindowSize. The window of a channel is then doubled, up to
+	// MaxWindowSize, whenever the other end sends half of it in less than
+	// two round trips, so throughput isn't limited by latency. Round trips
+	// are measured with pings.
+	MaxWindowSize uint32
+
+	// MaxSessionWindow bounds the memory used for unread data by capping
+	// the total window of all channels in the session. New channels get
+	// what is left of it if that is less than WindowSize, and opens are
+	// refused once none is left. Auto-tuning doesn't grow windows past it.
+	// It defaults to 16 times MaxWindowSize with auto-tuning, and to no
+	// limit without.
+	MaxSessionWindow uint64
+
+	// AcceptTimeout is how long an incoming channel waits to be accepted
+	// before the open is refused. It defaults to 30 seconds.
+	AcceptTimeout time.Duration
+
+	// AcceptBacklog is the number of incoming channels that may wait to be
+	// accepted. Opens beyond it are refused with ResourceShortage rather
+	// than holding up the session. It defaults to 16.
+	AcceptBacklog int
+
+	// MaxIncomingChannels limits how many channels opened by the other end
+	// may be open at once. Opens beyond it are refused with
+	// ResourceShortage. There is no limit if zero.
+	MaxIncomingChannels int
+
+	// QueueDepth is the number of pending control messages buffered for
+	// each channel. It defaults to 16.
+	QueueDepth int
+
+	// AcceptFilter, if set, is called for each incoming channel open before
+	// it is queued to be accepted. Returning an error refuses the open. An
+	// *OpenError sets the reason sent to the other end, other errors are sent
+	// with the Prohibited reason. It is called from the session read loop, so
+	// it should not block.
+	AcceptFilter func(req OpenRequest) error
+
+	// KeepAliveInterval is how often a ping is sent to the other end to
+	// check that it is still alive. Keepalive pings are disabled if zero.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveTimeout is how long to wait for a keepalive pong before
+	// closing the session with ErrKeepAliveTimeout. If zero, it defaults
+	// to KeepAliveInterval.
+	KeepAliveTimeout time.Duration
+
+	// Observer, if set, is notified of session, channel and frame events.
+	Observer observe.Observer
+
+	// Capture, if set, is given every frame the session sends and receives,
+	// such as to record them to a file with the capture package.
+	Capture FrameCapturer
+
+	// Handshaker, if set, runs a handshake over the transport when the
+	// session starts and the session runs over the transport it returns,
+	// such as to encrypt it. It runs before the Authenticator.
+	Handshaker Handshaker
+
+	// Authenticator, if set, runs an authentication handshake over the
+	// transport when the session starts. No frames are sent or received
+	// until it succeeds, and the session is closed if it fails.
+	Authenticator Authenticator
+
+	// WriteChunkSize is the largest data payload written in a single frame,
+	// so a large write doesn't hold up the transport while other channels
+	// wait their turn. It defaults to 32KB.
+	WriteChunkSize uint32
+
+	// WriteBatchDelay enables write batching when set. Frames are then
+	// gathered for up to this long and written to the transport together,
+	// trading latency for fewer writes, such as fewer WebSocket messages or
+	// system calls when many calls are in flight. Window adjusts, pings,
+	// pongs and resets are written right away along with the frames before
+	// them. Writes return once their frames are batched, and Close writes
+	// out the batch before closing the transport.
+	WriteBatchDelay time.Duration
+
+	// WriteBatchSize is the most bytes of frames gathered into one write
+	// when batching. It defaults to 64KB.
+	WriteBatchSize int
+
+	// AuthTimeout is how long the Handshaker and Authenticator handshakes
+	// may take before the session is closed with ErrAuthTimeout. It defaults
+	// to 10 seconds.
+	AuthTimeout time.Duration
+}
+
+// A FrameCapturer records the frames of a session. CaptureFrame is called
+// with each frame just before it is written to the transport and just after
+// it is read, so a frame sent in response to another is always captured
+// after it. It must not keep msg after returning.
+type FrameCapturer interface {
+	CaptureFrame(sent bool, msg frame.Message)
+}
+
+// OpenRequest describes an incoming channel open passed to Config.AcceptFilter.
+type OpenRequest struct {
+	// WindowSize is the initial window the other end has for receiving.
+	WindowSize uint32
+
+	// MaxPacketSize is the largest data payload the other end will receive.
+	MaxPacketSize uint32
+
+	// ChannelType is the type of the channel, empty for anonymous channels.
+	ChannelType string
+
+	// Extra is the extra data sent with a typed open.
+	Extra []byte
+}
+
+// withDefaults returns a copy of the config with defaults filled in
+// and out of range values clamped.
+func (c Config) withDefaults() Config {
+	if c.MaxPacketSize == 0 {
+		c.MaxPacketSize = channelMaxPacket
+	}
+	if c.MaxPacketSize < minPacketLength {
+		c.MaxPacketSize = minPacketLength
+	}
+	if c.MaxPacketSize > maxPacketLength {
+		c.MaxPacketSize = maxPacketLength
+	}
+	if c.WindowSize == 0 {
+		win := uint64(channelWindowSize/channelMaxPacket) * uint64(c.MaxPacketSize)
+		if win > math.MaxUint32 {
+			win = math.MaxUint32
+		}
+		c.WindowSize = uint32(win)
+	}
+	if c.MaxSessionWindow == 0 && c.MaxWindowSize > c.WindowSize {
+		c.MaxSessionWindow = 16 * uint64(c.MaxWindowSize)
+	}
+	if c.AcceptTimeout == 0 {
+		c.AcceptTimeout = openTimeout
+	}
+	if c.AcceptBacklog == 0 {
+		c.AcceptBacklog = acceptBacklog
+	}
+	if c.QueueDepth == 0 {
+		c.QueueDepth = chanSize
+	}
+	if c.KeepAliveTimeout == 0 {
+		c.KeepAliveTimeout = c.KeepAliveInterval
+	}
+	if c.Observer == nil {
+		c.Observer = observe.Nop{}
+	}
+	if c.AuthTimeout == 0 {
+		c.AuthTimeout = authTimeout
+	}
+	if c.WriteChunkSize == 0 {
+		c.WriteChunkSize = defaultWriteChunkSize
+	}
+	if c.WriteBatchSize == 0 {
+		c.WriteBatchSize = defaultWriteBatchSize
+	}
+	return c
+}
+
 type session struct {
+	// srtt is the smoothed round-trip time and windowTotal is the total
+	// window of all channels. They are accessed atomically and kept
+	// first for 64-bit alignment.
+	srtt        int64
+	windowTotal uint64
+
+	// incoming is the number of open channels opened by the other end,
+	// accessed atomically.
+	incoming int32
+
+	// announced and extended are set once the added frame types have been
+	// announced to the other end and once it has announced them, accessed
+	// atomically.
+	announced int32
+	extended  int32
+
 	t     io.ReadWriteCloser
+	cfg   Config
 	chans chanList
 
-	enc *frame.Encoder
-	dec *frame.Decoder
+	enc   *frame.Encoder
+	dec   *frame.Decoder
+	rbuf  *bufio.Reader // the transport the decoder reads from
+	sched *scheduler
+
+	// payload is the pooled buffer the current data frame was read into,
+	// if any, and claimed is the channel buffer whose waiting Read it was
+	// read into instead. Both are only used by the read loop.
+	payload *[]byte
+	claimed *buffer
+
+	inbox chan *channel
+
+	// handlersMu protects handlers and reqHandlers.
+	handlersMu  sync.Mutex
+	handlers    map[string]ChannelHandler
+	reqHandlers map[string]RequestHandler
+
+	reqMu     sync.Mutex
+	reqID     uint32
+	reqWaiter map[uint32]chan frame.Message
 
-	inbox chan Channel
+	// goAwayMu protects sentGoAway and recvGoAway, which are set once
+	// a GoAway has been sent to or received from the other end.
+	goAwayMu   sync.Mutex
+	sentGoAway bool
+	recvGoAway bool
+
+	pingMu     sync.Mutex
+	pingID     uint32
+	pingWaiter map[uint32]chan struct{}
+
+	stats *sessionStats
+
+	// ready is closed once the authentication handshake has succeeded,
+	// setting principal.
+	ready     chan struct{}
+	principal string
 
 	errCond *sync.Cond
 	err     error
-	closeCh chan bool
+	failErr error
+	done    chan struct{}
 }
 
-// NewSession returns a session that runs over the given transport.
+// New returns a session that runs over the given transport.
 func New(t io.ReadWriteCloser) Session {
+	return NewWithConfig(t, Config{})
+}
+
+// NewWithConfig returns a session that runs over the given transport
+// using the settings in cfg.
+func NewWithConfig(t io.ReadWriteCloser, cfg Config) Session {
 	if t == nil {
 		return nil
 	}
+	cfg = cfg.withDefaults()
 	s := &session{
-		t:       t,
-		enc:     frame.NewEncoder(t),
-		dec:     frame.NewDecoder(t),
-		inbox:   make(chan Channel),
-		errCond: sync.NewCond(new(sync.Mutex)),
-		closeCh: make(chan bool, 1),
+		t:           t,
+		cfg:         cfg,
+		sched:       newScheduler(),
+		inbox:       make(chan *channel, cfg.AcceptBacklog),
+		handlers:    make(map[string]ChannelHandler),
+		reqHandlers: make(map[string]RequestHandler),
+		reqWaiter:   make(map[uint32]chan frame.Message),
+		pingWaiter:  make(map[uint32]chan struct{}),
+		stats:       new(sessionStats),
+		ready:       make(chan struct{}),
+		errCond:     sync.NewCond(new(sync.Mutex)),
+		done:        make(chan struct{}),
 	}
+	s.enc = s.newEncoder(t)
+	s.dec = s.newDecoder(t)
+	s.cfg.Observer.SessionStart()
 	go s.loop()
+	if s.cfg.KeepAliveInterval > 0 {
+		go s.keepAlive(s.cfg.KeepAliveInterval, s.cfg.KeepAliveTimeout)
+	}
 	return s
 }
 
-// Close closes the underlying transport.
+// newEncoder returns a frame encoder for w,
+// which batches frames if enabled.
+func (s *session) newEncoder(w io.Writer) *frame.Encoder {
+	if s.cfg.WriteBatchDelay > 0 {
+		return frame.NewBatchEncoder(w, s.cfg.WriteBatchDelay, s.cfg.WriteBatchSize)
+	}
+	return frame.NewEncoder(w)
+}
+
+// newDecoder returns a frame decoder for r that refuses data
+// frames larger than the packets the session accepts.
+func (s *session) newDecoder(r io.Reader) *frame.Decoder {
+	s.rbuf = bufio.NewReaderSize(r, readBufferSize)
+	dec := frame.NewDecoder(s.rbuf)
+	dec.MaxDataLength = s.cfg.MaxPacketSize
+	dec.Alloc = s.allocPayload
+	return dec
+}
+
+// allocPayload returns the buffer to read the payload of a data frame
+// for a channel into. If the payload has already been buffered and a Read
+// is waiting on the channel with room for it, it is copied straight into
+// the destination of that Read. Otherwise it is read into a pooled buffer,
+// since a Read can't be held waiting on a peer that stalls mid-frame.
+func (s *session) allocPayload(id uint32, n int) []byte {
+	s.payload, s.claimed = nil, nil
+	if ch := s.chans.getChan(id); ch != nil && n <= int(ch.maxIncomingPayload) && n <= s.rbuf.Buffered() {
+		if p := ch.pending.claim(n); p != nil {
+			s.claimed = ch.pending
+			return p
+		}
+	}
+	s.payload = getPayload(n)
+	if s.payload == nil {
+		return nil
+	}
+	return *s.payload
+}
+
+// Close closes the underlying transport, first writing out any
+// batched frames.
 func (s *session) Close() error {
+	if s.cfg.WriteBatchDelay > 0 {
+		select {
+		case <-s.ready:
+			s.enc.Flush()
+		default:
+		}
+	}
 	s.t.Close()
 	return nil
 }
@@ -91,24 +473,274 @@ func (s *session) Wait() error {
 
 // Accept waits for and returns the next incoming channel.
 func (s *session) Accept() (Channel, error) {
+	ch, _, _, err := s.AcceptWithType()
+	return ch, err
+}
+
+// AcceptWithType waits for and returns the next incoming channel
+// along with its type and extra data.
+func (s *session) AcceptWithType() (Channel, string, []byte, error) {
+	for {
+		select {
+		case ch := <-s.inbox:
+			// skip channels refused while waiting in the backlog
+			if !atomic.CompareAndSwapInt32(&ch.acceptState, acceptWaiting, acceptTaken) {
+				continue
+			}
+			ch.acceptTimer.Stop()
+			// Opens wait in the backlog unconfirmed, since they may still be
+			// refused if they aren't accepted in time, so the confirm is sent
+			// once the channel is accepted. Sending it here rather than from
+			// the read loop also means it goes out before anything the caller
+			// writes on the channel.
+			if err := s.confirmOpen(ch); err != nil {
+				return nil, "", nil, err
+			}
+			return ch, ch.chanType, ch.extra, nil
+		case <-s.done:
+			return nil, "", nil, io.EOF
+		}
+	}
+}
+
+// HandleChannelType registers a handler for incoming channels of the given type.
+func (s *session) HandleChannelType(kind string, handler ChannelHandler) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	if handler == nil {
+		delete(s.handlers, kind)
+		return
+	}
+	s.handlers[kind] = handler
+}
+
+// Ping sends a ping to the other end and waits for the matching pong,
+// returning the round-trip time.
+func (s *session) Ping(ctx context.Context) (time.Duration, error) {
+	pong := make(chan struct{})
+	s.pingMu.Lock()
+	s.pingID++
+	id := s.pingID
+	s.pingWaiter[id] = pong
+	s.pingMu.Unlock()
+
+	defer func() {
+		s.pingMu.Lock()
+		delete(s.pingWaiter, id)
+		s.pingMu.Unlock()
+	}()
+
+	// The ping is written from its own goroutine so that a transport
+	// that blocks on write can't hold up the timeout.
+	start := time.Now()
+	sent := make(chan error, 1)
+	go func() {
+		sent <- s.encode(frame.PingMessage{
+			PingID: id,
+		})
+	}()
+
+	for {
+		select {
+		case err := <-sent:
+			if err != nil {
+				return 0, err
+			}
+			sent = nil
+		case <-pong:
+			rtt := time.Since(start)
+			s.updateRTT(rtt)
+			return rtt, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-s.done:
+			return 0, net.ErrClosed
+		}
+	}
+}
+
+// keepAlive pings the other end every interval until the session is
+// closed, failing the session if a pong does not arrive within timeout.
+func (s *session) keepAlive(interval, timeout time.Duration) {
 	select {
-	case ch := <-s.inbox:
-		return ch, nil
-	case <-s.closeCh:
-		return nil, io.EOF
+	case <-s.ready:
+	case <-s.done:
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.done:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_, err := s.Ping(ctx)
+		cancel()
+		if err == context.DeadlineExceeded {
+			s.fail(ErrKeepAliveTimeout)
+			return
+		}
+		if err != nil {
+			return
+		}
 	}
 }
 
+// fail closes the transport, making err the error returned by Wait.
+func (s *session) fail(err error) {
+	s.errCond.L.Lock()
+	if s.failErr == nil {
+		s.failErr = err
+	}
+	s.errCond.L.Unlock()
+	s.t.Close()
+}
+
+// Principal returns the principal the other end authenticated as.
+func (s *session) Principal() string {
+	select {
+	case <-s.ready:
+		return s.principal
+	default:
+		return ""
+	}
+}
+
+// PeerCertificates returns the certificate chain presented by the other end
+// if the transport is a TLS connection.
+func (s *session) PeerCertificates() []*x509.Certificate {
+	if c, ok := s.t.(connectionStater); ok {
+		return c.ConnectionState().PeerCertificates
+	}
+	return nil
+}
+
+// Shutdown gracefully shuts down the session. It tells the other end to
+// stop opening channels if it supports GoAway, refuses any that are opened
+// anyway, and waits for the open channels to close before closing the
+// transport. If ctx expires first, the transport is closed and the context
+// error returned.
+func (s *session) Shutdown(ctx context.Context) error {
+	s.goAwayMu.Lock()
+	sent := s.sentGoAway
+	s.sentGoAway = true
+	s.goAwayMu.Unlock()
+
+	if !sent && s.peerExtended() {
+		if err := s.encode(frame.GoAwayMessage{}); err != nil {
+			s.Close()
+			return err
+		}
+	}
+
+	select {
+	case <-s.chans.emptied():
+		return s.Close()
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	case <-s.done:
+		return nil
+	}
+}
+
+// goingAway returns true if either end has started shutting down.
+func (s *session) goingAway() bool {
+	s.goAwayMu.Lock()
+	defer s.goAwayMu.Unlock()
+	return s.sentGoAway || s.recvGoAway
+}
+
+// SendRequest sends a global request to the other end, waiting
+// for the reply if wantReply is true.
+func (s *session) SendRequest(ctx context.Context, name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	var id uint32
+	var reply chan frame.Message
+	if wantReply {
+		reply = make(chan frame.Message, 1)
+		s.reqMu.Lock()
+		s.reqID++
+		id = s.reqID
+		s.reqWaiter[id] = reply
+		s.reqMu.Unlock()
+
+		defer func() {
+			s.reqMu.Lock()
+			delete(s.reqWaiter, id)
+			s.reqMu.Unlock()
+		}()
+	}
+
+	if err := s.encode(frame.GlobalRequestMessage{
+		RequestID: id,
+		Name:      name,
+		WantReply: wantReply,
+		Payload:   payload,
+	}); err != nil {
+		return false, nil, err
+	}
+	if !wantReply {
+		return false, nil, nil
+	}
+
+	select {
+	case m := <-reply:
+		switch msg := m.(type) {
+		case *frame.RequestSuccessMessage:
+			return true, msg.Payload, nil
+		case *frame.RequestFailureMessage:
+			return false, msg.Payload, nil
+		default:
+			return false, nil, fmt.Errorf("qmux: unexpected packet in response to global request: %v", msg)
+		}
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	case <-s.done:
+		return false, nil, net.ErrClosed
+	}
+}
+
+// HandleRequest registers a handler for incoming global requests with the given name.
+func (s *session) HandleRequest(name string, handler RequestHandler) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	if handler == nil {
+		delete(s.reqHandlers, name)
+		return
+	}
+	s.reqHandlers[name] = handler
+}
+
 // Open establishes a new channel with the other end.
 func (s *session) Open(ctx context.Context) (Channel, error) {
-	ch := s.newChannel(channelOutbound)
-	ch.maxIncomingPayload = channelMaxPacket
+	return s.OpenWithType(ctx, "", nil)
+}
 
-	if err := s.enc.Encode(frame.OpenMessage{
+// OpenWithType establishes a new channel of the given type with the other end.
+func (s *session) OpenWithType(ctx context.Context, kind string, extra []byte) (Channel, error) {
+	if s.goingAway() {
+		return nil, ErrGoAway
+	}
+
+	win := uint32(s.reserveWindow(uint64(s.cfg.WindowSize)))
+	if win == 0 {
+		return nil, ErrWindowExhausted
+	}
+	ch := s.newChannel(channelOutbound, win)
+	ch.maxIncomingPayload = s.cfg.MaxPacketSize
+	ch.chanType = kind
+	ch.extra = extra
+
+	if err := s.encode(frame.OpenMessage{
 		WindowSize:    ch.myWindow,
 		MaxPacketSize: ch.maxIncomingPayload,
 		SenderID:      ch.localId,
+		ChannelType:   kind,
+		Extra:         extra,
 	}); err != nil {
+		s.abandonOpen(ch)
 		return nil, err
 	}
 
@@ -116,34 +748,112 @@ func (s *session) Open(ctx context.Context) (Channel, error) {
 
 	select {
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		if s.abandonOpen(ch) {
+			return nil, ctx.Err()
+		}
+		// the other end responded as ctx expired
+		if m = <-ch.msg; m == nil {
+			return nil, net.ErrClosed
+		}
 	case m = <-ch.msg:
 		if m == nil {
 			// channel was closed before open got a response,
 			// typically meaning the session/conn was closed.
 			return nil, net.ErrClosed
 		}
+	case <-s.done:
+		// the session ended before the channel was added, which
+		// isn't noticed writing the open if writes are batched
+		select {
+		case m = <-ch.msg:
+		default:
+		}
+		if m == nil {
+			return nil, net.ErrClosed
+		}
 	}
 
 	switch msg := m.(type) {
 	case *frame.OpenConfirmMessage:
+		atomic.AddUint64(&s.stats.opened, 1)
+		ch.observeOpen()
 		return ch, nil
 	case *frame.OpenFailureMessage:
-		return nil, fmt.Errorf("qmux: channel open failed on remote side")
+		atomic.AddUint64(&s.stats.openFailed, 1)
+		return nil, &OpenError{
+			Reason:  RejectionReason(msg.Reason),
+			Message: msg.Description,
+		}
 	default:
 		return nil, fmt.Errorf("qmux: unexpected packet in response to channel open: %v", msg)
 	}
 }
 
-func (s *session) newChannel(direction channelDirection) *channel {
+// abandonOpen gives up waiting for the other end to respond to the open
+// of ch, unless it already has, and reports whether it did. The channel no
+// longer counts as open, and is closed if the other end confirms it later.
+func (s *session) abandonOpen(ch *channel) bool {
+	if !atomic.CompareAndSwapInt32(&ch.openState, openWaiting, openAbandoned) {
+		return false
+	}
+	s.chans.detach(ch.localId)
+	ch.releaseWindow()
+	return true
+}
+
+// encode writes a control message to the transport ahead of any queued
+// data, counting it in the session stats and notifying the observer.
+func (s *session) encode(msg frame.Message) error {
+	return s.encodeOn(nil, msg)
+}
+
+// encodeOn writes a message of ch to the transport. Data messages wait
+// for the scheduler to give ch its turn, other messages are written after
+// any data of ch that is waiting.
+func (s *session) encodeOn(ch *channel, msg frame.Message) error {
+	if err := s.waitReady(); err != nil {
+		return err
+	}
+	if err := s.write(ch, msg); err != nil {
+		return err
+	}
+	name, size := frameType(msg), frameSize(msg)
+	s.stats.frameSent(name, size)
+	s.cfg.Observer.FrameSent(name, size)
+	return nil
+}
+
+// waitReady waits for the authentication handshake to finish, returning
+// the error that closed the session if it failed.
+func (s *session) waitReady() error {
+	select {
+	case <-s.ready:
+		return nil
+	default:
+	}
+	select {
+	case <-s.ready:
+		return nil
+	case <-s.done:
+		return s.Wait()
+	}
+}
+
+// newChannel adds a channel with a window of win bytes, which must
+// already be reserved against the session total.
+func (s *session) newChannel(direction channelDirection, win uint32) *channel {
 	ch := &channel{
 		remoteWin: window{Cond: sync.NewCond(new(sync.Mutex))},
-		myWindow:  channelWindowSize,
+		myWindow:  win,
+		winSize:   win,
+		epoch:     time.Now(),
 		pending:   newBuffer(),
 		direction: direction,
-		msg:       make(chan frame.Message, chanSize),
+		msg:       make(chan frame.Message, s.cfg.QueueDepth),
+		done:      make(chan struct{}),
 		session:   s,
 		packetBuf: make([]byte, 0),
+		priority:  DefaultPriority,
 	}
 	ch.localId = s.chans.add(ch)
 	return ch
@@ -152,7 +862,13 @@ func (s *session) newChannel(direction channelDirection) *channel {
 // loop runs the connection machine. It will process packets until an
 // error is encountered. To synchronize on loop exit, use session.Wait.
 func (s *session) loop() {
-	var err error
+	err := s.handshake()
+	if err == nil {
+		go s.writeLoop()
+		if s.autoTune() {
+			go s.measureRTT()
+		}
+	}
 	for err == nil {
 		err = s.onePacket()
 	}
@@ -162,12 +878,61 @@ func (s *session) loop() {
 	}
 
 	s.t.Close()
-	s.closeCh <- true
+	close(s.done)
 
 	s.errCond.L.Lock()
+	if s.failErr != nil {
+		err = s.failErr
+	}
 	s.err = err
 	s.errCond.Broadcast()
 	s.errCond.L.Unlock()
+
+	s.cfg.Observer.SessionEnd(err)
+}
+
+// handshake runs the handshakes of the configured Handshaker and
+// Authenticator, if any, before the session starts exchanging frames.
+func (s *session) handshake() error {
+	if s.cfg.Handshaker == nil && s.cfg.Authenticator == nil {
+		close(s.ready)
+		return nil
+	}
+	t := time.AfterFunc(s.cfg.AuthTimeout, func() {
+		s.fail(ErrAuthTimeout)
+	})
+	rw, principal, err := s.runHandshakes()
+	if !t.Stop() {
+		return ErrAuthTimeout
+	}
+	if err != nil {
+		return err
+	}
+	if rw != s.t {
+		s.enc = s.newEncoder(rw)
+		s.dec = s.newDecoder(rw)
+	}
+	s.principal = principal
+	close(s.ready)
+	return nil
+}
+
+// runHandshakes returns the transport to run the session over
+// and the principal of the other end.
+func (s *session) runHandshakes() (io.ReadWriteCloser, string, error) {
+	rw := s.t
+	if s.cfg.Handshaker != nil {
+		var err error
+		rw, err = s.cfg.Handshaker.Handshake(rw)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if s.cfg.Authenticator == nil {
+		return rw, "", nil
+	}
+	principal, err := s.cfg.Authenticator.Authenticate(rw)
+	return rw, principal, err
 }
 
 // onePacket reads and processes one packet.
@@ -176,13 +941,50 @@ func (s *session) onePacket() error {
 	var msg frame.Message
 
 	msg, err = s.dec.Decode()
+	if claimed := s.claimed; claimed != nil {
+		// a Read claimed for the frame goes back to waiting unless
+		// the frame is handed to it
+		s.claimed = nil
+		defer claimed.release()
+	}
 	if err != nil {
 		return err
 	}
+	name, size := frameType(msg), frameSize(msg)
+	s.stats.frameReceived(name, size)
+	s.cfg.Observer.FrameReceived(name, size)
+	if s.cfg.Capture != nil {
+		s.cfg.Capture.CaptureFrame(false, msg)
+	}
 
 	id, isChan := msg.Channel()
 	if !isChan {
-		return s.handleOpen(msg.(*frame.OpenMessage))
+		switch m := msg.(type) {
+		case *frame.OpenMessage:
+			return s.handleOpen(m)
+		case *frame.PingMessage:
+			return s.encode(frame.PongMessage{
+				PingID: m.PingID,
+			})
+		case *frame.PongMessage:
+			s.handlePong(m)
+			return nil
+		case *frame.GlobalRequestMessage:
+			return s.handleRequest(m)
+		case *frame.RequestSuccessMessage:
+			s.handleRequestReply(m.RequestID, m)
+			return nil
+		case *frame.RequestFailureMessage:
+			s.handleRequestReply(m.RequestID, m)
+			return nil
+		case *frame.GoAwayMessage:
+			s.goAwayMu.Lock()
+			s.recvGoAway = true
+			s.goAwayMu.Unlock()
+			return nil
+		default:
+			return fmt.Errorf("qmux: unexpected session message %v", msg)
+		}
 	}
 
 	ch := s.chans.getChan(id)
@@ -193,32 +995,173 @@ func (s *session) onePacket() error {
 	return ch.handle(msg)
 }
 
+// handlePong wakes up the Ping call waiting on the pong, if any.
+func (s *session) handlePong(msg *frame.PongMessage) {
+	s.pingMu.Lock()
+	defer s.pingMu.Unlock()
+	if pong, ok := s.pingWaiter[msg.PingID]; ok {
+		close(pong)
+		delete(s.pingWaiter, msg.PingID)
+	}
+}
+
+// handleRequest runs the handler for a global request in its own goroutine,
+// or fails the request if there is no handler.
+func (s *session) handleRequest(msg *frame.GlobalRequestMessage) error {
+	s.handlersMu.Lock()
+	handler := s.reqHandlers[msg.Name]
+	s.handlersMu.Unlock()
+
+	if handler == nil {
+		if !msg.WantReply {
+			return nil
+		}
+		return s.encode(frame.RequestFailureMessage{
+			RequestID: msg.RequestID,
+		})
+	}
+
+	go func() {
+		payload, err := handler(msg.Payload)
+		if !msg.WantReply {
+			return
+		}
+		if err != nil {
+			s.encode(frame.RequestFailureMessage{
+				RequestID: msg.RequestID,
+				Payload:   []byte(err.Error()),
+			})
+			return
+		}
+		s.encode(frame.RequestSuccessMessage{
+			RequestID: msg.RequestID,
+			Payload:   payload,
+		})
+	}()
+	return nil
+}
+
+// handleRequestReply passes a global request reply to the SendRequest
+// call waiting on it, if any.
+func (s *session) handleRequestReply(id uint32, msg frame.Message) {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
+	if reply, ok := s.reqWaiter[id]; ok {
+		reply <- msg
+		delete(s.reqWaiter, id)
+	}
+}
+
 // handleChannelOpen schedules a channel to be Accept()ed.
 func (s *session) handleOpen(msg *frame.OpenMessage) error {
+	// typed opens are one of the added frame types
+	if msg.ChannelType != "" || len(msg.Extra) > 0 {
+		s.setPeerExtended()
+	}
 	if msg.MaxPacketSize < minPacketLength || msg.MaxPacketSize > maxPacketLength {
-		return s.enc.Encode(frame.OpenFailureMessage{
-			ChannelID: msg.SenderID,
+		return s.rejectOpen(msg.SenderID, InvalidPacketSize, fmt.Sprintf("max packet size %d out of range", msg.MaxPacketSize))
+	}
+	if s.goingAway() {
+		return s.rejectOpen(msg.SenderID, ShuttingDown, "")
+	}
+	if max := s.cfg.MaxIncomingChannels; max > 0 && int(atomic.LoadInt32(&s.incoming)) >= max {
+		return s.rejectOpen(msg.SenderID, ResourceShortage, "too many channels")
+	}
+	if s.cfg.AcceptFilter != nil {
+		err := s.cfg.AcceptFilter(OpenRequest{
+			WindowSize:    msg.WindowSize,
+			MaxPacketSize: msg.MaxPacketSize,
+			ChannelType:   msg.ChannelType,
+			Extra:         msg.Extra,
 		})
+		if err != nil {
+			var openErr *OpenError
+			if errors.As(err, &openErr) {
+				return s.rejectOpen(msg.SenderID, openErr.Reason, openErr.Message)
+			}
+			return s.rejectOpen(msg.SenderID, Prohibited, err.Error())
+		}
 	}
 
-	c := s.newChannel(channelInbound)
+	win := uint32(s.reserveWindow(uint64(s.cfg.WindowSize)))
+	if win == 0 {
+		return s.rejectOpen(msg.SenderID, ResourceShortage, "session window exhausted")
+	}
+	c := s.newChannel(channelInbound, win)
+	atomic.AddInt32(&s.incoming, 1)
 	c.remoteId = msg.SenderID
 	c.maxRemotePayload = msg.MaxPacketSize
 	c.remoteWin.add(msg.WindowSize)
-	c.maxIncomingPayload = channelMaxPacket
-	t := time.NewTimer(openTimeout)
-	defer t.Stop()
+	c.maxIncomingPayload = s.cfg.MaxPacketSize
+	c.chanType = msg.ChannelType
+	c.extra = msg.Extra
+
+	s.handlersMu.Lock()
+	handler := s.handlers[msg.ChannelType]
+	s.handlersMu.Unlock()
+	if handler != nil {
+		if err := s.confirmOpen(c); err != nil {
+			return err
+		}
+		go handler(c, c.extra)
+		return nil
+	}
+
+	// The channel waits in the backlog so that the read loop isn't held
+	// up by a slow acceptor. It is refused if it isn't accepted in time.
+	c.acceptTimer = time.AfterFunc(s.cfg.AcceptTimeout, func() {
+		s.refuseOpen(c, AcceptTimeout, "")
+	})
 	select {
 	case s.inbox <- c:
-		return s.enc.Encode(frame.OpenConfirmMessage{
-			ChannelID:     c.remoteId,
-			SenderID:      c.localId,
-			WindowSize:    c.myWindow,
-			MaxPacketSize: c.maxIncomingPayload,
-		})
-	case <-t.C:
-		return s.enc.Encode(frame.OpenFailureMessage{
-			ChannelID: msg.SenderID,
-		})
+		return nil
+	default:
+		c.acceptTimer.Stop()
+		return s.refuseOpen(c, ResourceShortage, "accept backlog full")
+	}
+}
+
+// refuseOpen refuses an incoming channel that hasn't been accepted yet,
+// unless it has just been accepted or closed.
+func (s *session) refuseOpen(c *channel, reason RejectionReason, message string) error {
+	if !atomic.CompareAndSwapInt32(&c.acceptState, acceptWaiting, acceptRefused) {
+		return nil
+	}
+	// the channel is closed here unless the session closed it first
+	if s.chans.remove(c.localId) {
+		c.close()
+	}
+	return s.rejectOpen(c.remoteId, reason, message)
+}
+
+// confirmOpen accepts a channel open from the other end.
+func (s *session) confirmOpen(c *channel) error {
+	if err := s.announce(c.remoteId); err != nil {
+		return err
+	}
+	if err := s.encode(frame.OpenConfirmMessage{
+		ChannelID:     c.remoteId,
+		SenderID:      c.localId,
+		WindowSize:    c.myWindow,
+		MaxPacketSize: c.maxIncomingPayload,
+	}); err != nil {
+		return err
+	}
+	atomic.AddUint64(&s.stats.accepted, 1)
+	c.observeOpen()
+	return nil
+}
+
+// rejectOpen refuses a channel open from the other end. The reason is
+// only sent if the other end supports open failure reasons.
+func (s *session) rejectOpen(remoteId uint32, reason RejectionReason, message string) error {
+	atomic.AddUint64(&s.stats.rejected, 1)
+	msg := frame.OpenFailureMessage{
+		ChannelID: remoteId,
+	}
+	if s.peerExtended() {
+		msg.Reason = uint32(reason)
+		msg.Description = message
 	}
+	return s.encode(msg)
 }
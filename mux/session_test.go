@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/progrium/qtalk-go/mux/frame"
 )
 
 func init() {
@@ -130,6 +135,12 @@ func TestSessionOpenServerTimeout(t *testing.T) {
 		sess := New(conn)
 		defer sess.Close()
 
+		// a channel from the other end tells it that open failure
+		// reasons are supported
+		ch, err := sess.Accept()
+		fatal(err, t)
+		ch.Close()
+
 		_, err = sess.Open(context.Background())
 		errCh <- err
 	}()
@@ -140,10 +151,18 @@ func TestSessionOpenServerTimeout(t *testing.T) {
 
 	sess := New(conn)
 	defer sess.Close()
+	ch, err := sess.Open(context.Background())
+	fatal(err, t)
+	ch.Close()
 
-	if <-errCh == nil {
+	err = <-errCh
+	if err == nil {
 		t.Errorf("expected open to fail when listener doesn't call Accept")
 	}
+	var openErr *OpenError
+	if !errors.As(err, &openErr) || openErr.Reason != AcceptTimeout {
+		t.Errorf("expected open to fail with AcceptTimeout, but got: %v", err)
+	}
 	fatal(sess.Close(), t)
 }
 
@@ -165,3 +184,626 @@ func TestSessionWait(t *testing.T) {
 		t.Fatalf("expected a network error, but got: %v", err)
 	}
 }
+
+func TestSessionPing(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := New(b)
+	defer sessA.Close()
+	defer sessB.Close()
+
+	rtt, err := sessA.(Pinger).Ping(context.Background())
+	fatal(err, t)
+	if rtt <= 0 {
+		t.Fatalf("unexpected round-trip time: %v", rtt)
+	}
+}
+
+func TestSessionKeepAliveTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	// the other end reads frames but never answers them
+	go io.Copy(ioutil.Discard, b)
+
+	sess := NewWithConfig(a, Config{
+		KeepAliveInterval: 10 * time.Millisecond,
+		KeepAliveTimeout:  20 * time.Millisecond,
+	})
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- sess.Wait()
+	}()
+
+	select {
+	case err := <-waitErr:
+		if err != ErrKeepAliveTimeout {
+			t.Fatalf("expected ErrKeepAliveTimeout, but got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session was not closed by keepalive")
+	}
+}
+
+func TestSessionShutdown(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := New(b)
+	defer sessB.Close()
+
+	go func() {
+		_, err := sessB.Open(context.Background())
+		fatal(err, t)
+	}()
+	chA, err := sessA.Accept()
+	fatal(err, t)
+	waitExtended(t, sessA)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- sessA.(Shutdowner).Shutdown(context.Background())
+	}()
+
+	// wait for the GoAway to arrive
+	for !sessB.(*session).goingAway() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := sessB.Open(context.Background()); err != ErrGoAway {
+		t.Fatalf("expected ErrGoAway, but got: %v", err)
+	}
+
+	// existing channels keep working until closed
+	_, err = chA.Write([]byte("Hello world"))
+	fatal(err, t)
+	fatal(chA.Close(), t)
+
+	select {
+	case err := <-shutdownErr:
+		fatal(err, t)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not finish after channels closed")
+	}
+}
+
+func TestSessionOpenCancel(t *testing.T) {
+	sess, peer := newBaselinePeer(t, Config{})
+	openCancelled := func() *frame.OpenMessage {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := sess.Open(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("expected DeadlineExceeded, but got: %v", err)
+		}
+		return peer.next(t).(*frame.OpenMessage)
+	}
+
+	// a channel confirmed after Open gave up is closed
+	open := openCancelled()
+	fatal(peer.enc.Encode(frame.OpenConfirmMessage{
+		ChannelID:     open.SenderID,
+		SenderID:      3,
+		WindowSize:    1 << 16,
+		MaxPacketSize: 1 << 16,
+	}), t)
+	for {
+		if msg, ok := peer.next(t).(*frame.CloseMessage); ok {
+			if msg.ChannelID != 3 {
+				t.Fatalf("unexpected close: %v", msg)
+			}
+			break
+		}
+	}
+	fatal(peer.enc.Encode(frame.CloseMessage{ChannelID: open.SenderID}), t)
+
+	// and one never responded to doesn't hold up a shutdown
+	openCancelled()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	fatal(sess.(Shutdowner).Shutdown(ctx), t)
+}
+
+func TestSessionShutdownTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := New(b)
+	defer sessB.Close()
+
+	go func() {
+		_, err := sessB.Open(context.Background())
+		fatal(err, t)
+	}()
+	_, err := sessA.Accept()
+	fatal(err, t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = sessA.(Shutdowner).Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, but got: %v", err)
+	}
+}
+
+func TestSessionConfig(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := NewWithConfig(a, Config{
+		MaxPacketSize: 1024,
+		WindowSize:    4096,
+	})
+	sessB := New(b)
+	defer sessA.Close()
+	defer sessB.Close()
+
+	go func() {
+		ch, err := sessA.Open(context.Background())
+		fatal(err, t)
+		b, err := ioutil.ReadAll(ch)
+		fatal(err, t)
+		_, err = ch.Write(b)
+		fatal(err, t)
+		fatal(ch.Close(), t)
+	}()
+
+	ch, err := sessB.Accept()
+	fatal(err, t)
+	if max := ch.(*channel).maxRemotePayload; max != 1024 {
+		t.Fatalf("unexpected remote max packet size: %d", max)
+	}
+	if win := ch.(*channel).remoteWin.win; win != 4096 {
+		t.Fatalf("unexpected remote window size: %d", win)
+	}
+
+	data := bytes.Repeat([]byte("qmux"), 4096)
+	_, err = ch.Write(data)
+	fatal(err, t)
+	fatal(ch.CloseWrite(), t)
+	b2, err := ioutil.ReadAll(ch)
+	fatal(err, t)
+	if !bytes.Equal(b2, data) {
+		t.Fatalf("unexpected bytes of length %d", len(b2))
+	}
+}
+
+func TestSessionAcceptFilter(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := NewWithConfig(b, Config{
+		AcceptFilter: func(req OpenRequest) error {
+			if req.MaxPacketSize > 1024 {
+				return &OpenError{Reason: ResourceShortage, Message: "packets too big"}
+			}
+			return nil
+		},
+	})
+	defer sessA.Close()
+	defer sessB.Close()
+	negotiate(t, sessB, sessA)
+
+	_, err := sessA.Open(context.Background())
+	var openErr *OpenError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("expected an OpenError, but got: %v", err)
+	}
+	if openErr.Reason != ResourceShortage || openErr.Message != "packets too big" {
+		t.Fatalf("unexpected open error: %v", openErr)
+	}
+}
+
+func TestSessionTypedOpen(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a).(TypedSession)
+	sessB := NewWithConfig(b, Config{
+		AcceptFilter: func(req OpenRequest) error {
+			if req.ChannelType == "unknown" {
+				return &OpenError{Reason: UnknownChannelType}
+			}
+			return nil
+		},
+	}).(TypedSession)
+	defer sessA.Close()
+	defer sessB.Close()
+
+	sessB.HandleChannelType("echo", func(ch Channel, extra []byte) {
+		ch.Write(extra)
+		ch.Close()
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		ch, err := sessA.OpenWithType(context.Background(), "echo", []byte("Hello world"))
+		fatal(err, t)
+		b, err := ioutil.ReadAll(ch)
+		fatal(err, t)
+		if string(b) != "Hello world" {
+			t.Fatalf("unexpected bytes: %s", b)
+		}
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		go func() {
+			_, err := sessA.OpenWithType(context.Background(), "tunnel", []byte("localhost:80"))
+			fatal(err, t)
+		}()
+		ch, kind, extra, err := sessB.AcceptWithType()
+		fatal(err, t)
+		defer ch.Close()
+		if kind != "tunnel" || string(extra) != "localhost:80" {
+			t.Fatalf("unexpected type %q and extra %q", kind, extra)
+		}
+	})
+
+	t.Run("unknown type", func(t *testing.T) {
+		_, err := sessA.OpenWithType(context.Background(), "unknown", nil)
+		var openErr *OpenError
+		if !errors.As(err, &openErr) || openErr.Reason != UnknownChannelType {
+			t.Fatalf("expected UnknownChannelType, but got: %v", err)
+		}
+	})
+}
+
+func TestSessionRequest(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a).(Requester)
+	sessB := New(b).(Requester)
+	defer sessA.(Session).Close()
+	defer sessB.(Session).Close()
+
+	reloaded := make(chan string, 1)
+	sessB.HandleRequest("reload", func(payload []byte) ([]byte, error) {
+		reloaded <- string(payload)
+		return nil, nil
+	})
+	sessB.HandleRequest("version", func(payload []byte) ([]byte, error) {
+		if string(payload) != "v1" {
+			return nil, errors.New("unsupported version")
+		}
+		return []byte("v1"), nil
+	})
+	ctx := context.Background()
+
+	t.Run("reply", func(t *testing.T) {
+		ok, reply, err := sessA.SendRequest(ctx, "version", true, []byte("v1"))
+		fatal(err, t)
+		if !ok || string(reply) != "v1" {
+			t.Fatalf("unexpected reply: %v %s", ok, reply)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		ok, reply, err := sessA.SendRequest(ctx, "version", true, []byte("v2"))
+		fatal(err, t)
+		if ok || string(reply) != "unsupported version" {
+			t.Fatalf("unexpected reply: %v %s", ok, reply)
+		}
+	})
+
+	t.Run("no handler", func(t *testing.T) {
+		ok, _, err := sessA.SendRequest(ctx, "unknown", true, nil)
+		fatal(err, t)
+		if ok {
+			t.Fatal("expected request without handler to fail")
+		}
+	})
+
+	t.Run("no reply", func(t *testing.T) {
+		_, _, err := sessA.SendRequest(ctx, "reload", false, []byte("config.json"))
+		fatal(err, t)
+		if got := <-reloaded; got != "config.json" {
+			t.Fatalf("unexpected payload: %s", got)
+		}
+	})
+}
+
+func TestSessionStats(t *testing.T) {
+	sessA, sessB := newPipeSessions(t)
+	chA, chB := openPair(t, sessA, sessB)
+
+	_, err := chA.Write([]byte("hello"))
+	fatal(err, t)
+	for chB.(ChannelStatsReporter).Stats().Pending != 5 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := sessA.(StatsReporter).Stats()
+	if stats.OpenChannels != 1 || stats.Opened != 1 || stats.Accepted != 0 {
+		t.Fatalf("unexpected channel counts: %+v", stats)
+	}
+	if got := stats.FramesSent["Data"]; got.Frames != 1 || got.Bytes != 14 {
+		t.Fatalf("unexpected data frame stats: %+v", got)
+	}
+	if got := stats.FramesSent["Open"]; got.Frames != 1 {
+		t.Fatalf("unexpected open frame stats: %+v", got)
+	}
+	if stats.BytesSent == 0 || stats.BytesReceived == 0 {
+		t.Fatalf("unexpected byte counts: %+v", stats)
+	}
+
+	a := chA.(ChannelStatsReporter).Stats()
+	if a.BytesSent != 5 || a.RemoteWindow != channelWindowSize-5 {
+		t.Fatalf("unexpected sender stats: %+v", a)
+	}
+	b := chB.(ChannelStatsReporter).Stats()
+	if b.BytesReceived != 5 || b.LocalWindow != channelWindowSize-5 {
+		t.Fatalf("unexpected receiver stats: %+v", b)
+	}
+
+	_, err = chB.Read(make([]byte, 5))
+	fatal(err, t)
+	if b := chB.(ChannelStatsReporter).Stats(); b.Pending != 0 {
+		t.Fatalf("unexpected pending bytes after read: %d", b.Pending)
+	}
+	if got := sessB.(StatsReporter).Stats(); got.Accepted != 1 || len(got.Channels) != 1 {
+		t.Fatalf("unexpected accepting session stats: %+v", got)
+	}
+}
+
+// fuzzConn is a transport that reads frames from a fixed buffer once the
+// session has written its first frame, and discards what is written.
+type fuzzConn struct {
+	r       *bytes.Reader
+	written chan struct{}
+	once    sync.Once
+}
+
+func (c *fuzzConn) Read(p []byte) (int, error) {
+	<-c.written
+	return c.r.Read(p)
+}
+
+func (c *fuzzConn) Write(p []byte) (int, error) {
+	c.once.Do(func() { close(c.written) })
+	return len(p), nil
+}
+
+func (c *fuzzConn) Close() error {
+	c.once.Do(func() { close(c.written) })
+	return nil
+}
+
+// FuzzSession feeds arbitrary frames to a session with one channel it
+// opened and any number opened by the other end, which must not panic
+// or hang the session.
+func FuzzSession(f *testing.F) {
+	seed := func(msgs ...frame.Message) {
+		var buf bytes.Buffer
+		enc := frame.NewEncoder(&buf)
+		for _, msg := range msgs {
+			if err := enc.Encode(msg); err != nil {
+				f.Fatal(err)
+			}
+		}
+		f.Add(buf.Bytes())
+	}
+	seed(
+		frame.OpenConfirmMessage{ChannelID: 0, SenderID: 7, WindowSize: 1024, MaxPacketSize: 1024},
+		frame.DataMessage{ChannelID: 0, Length: 5, Data: []byte("hello")},
+		frame.WindowAdjustMessage{ChannelID: 0, AdditionalBytes: 1024},
+		frame.EOFMessage{ChannelID: 0},
+		frame.CloseMessage{ChannelID: 0},
+	)
+	seed(
+		frame.OpenFailureMessage{ChannelID: 0, Reason: 4, Description: "nope"},
+		frame.OpenMessage{SenderID: 3, WindowSize: 1024, MaxPacketSize: 1024},
+		frame.DataMessage{ChannelID: 1, Length: 5, Data: []byte("hello")},
+		frame.CloseMessage{ChannelID: 1},
+	)
+	seed(
+		frame.PingMessage{PingID: 1},
+		frame.GlobalRequestMessage{RequestID: 1, Name: "reload", WantReply: true},
+		frame.PongMessage{PingID: 1},
+		frame.GoAwayMessage{},
+	)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		sess := NewWithConfig(&fuzzConn{
+			r:       bytes.NewReader(b),
+			written: make(chan struct{}),
+		}, Config{
+			WindowSize:    1 << 16,
+			MaxPacketSize: 1 << 16,
+		})
+		var wg sync.WaitGroup
+		use := func(ch Channel) {
+			defer wg.Done()
+			ch.Write([]byte("hello"))
+			io.Copy(ioutil.Discard, ch)
+			ch.Close()
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				ch, err := sess.Accept()
+				if err != nil {
+					return
+				}
+				wg.Add(1)
+				go use(ch)
+			}
+		}()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			ch, err := sess.Open(ctx)
+			if err != nil {
+				wg.Done()
+				return
+			}
+			use(ch)
+		}()
+		sess.Wait()
+		sess.Close()
+		wg.Wait()
+	})
+}
+
+// benchTransports connect the sessions of benchmarks.
+var benchTransports = []struct {
+	name string
+	pipe func(b *testing.B) (net.Conn, net.Conn)
+}{
+	{"pipe", func(*testing.B) (net.Conn, net.Conn) { return net.Pipe() }},
+	{"tcp", tcpPipe},
+}
+
+// tcpPipe returns both ends of a loopback TCP connection.
+func tcpPipe(b *testing.B) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return conn, <-accepted
+}
+
+// BenchmarkChannelTransfer streams data over one channel, reporting the
+// allocations made per 32KB write.
+func BenchmarkChannelTransfer(b *testing.B) {
+	for _, tr := range benchTransports {
+		b.Run(tr.name, func(b *testing.B) {
+			a, c := tr.pipe(b)
+			sessA := New(a)
+			sessB := New(c)
+			defer sessA.Close()
+			defer sessB.Close()
+
+			accepted := make(chan Channel, 1)
+			go func() {
+				ch, _ := sessB.Accept()
+				accepted <- ch
+			}()
+			chA, err := sessA.Open(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+			chB := <-accepted
+
+			buf := make([]byte, 32<<10)
+			done := make(chan error, 1)
+			go func() {
+				_, err := io.CopyBuffer(ioutil.Discard, chB, make([]byte, 64<<10))
+				done <- err
+			}()
+
+			b.SetBytes(int64(len(buf)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := chA.Write(buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			chA.CloseWrite()
+			if err := <-done; err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+// countConn counts the writes made to a net.Conn.
+type countConn struct {
+	net.Conn
+	writes int64
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+func TestWriteBatching(t *testing.T) {
+	a, b := net.Pipe()
+	conn := &countConn{Conn: a}
+	sessA := NewWithConfig(conn, Config{WriteBatchDelay: 10 * time.Millisecond})
+	sessB := New(b)
+	defer sessB.Close()
+	chA, chB := openPair(t, sessA, sessB)
+
+	done := make(chan []byte)
+	go func() {
+		b, err := ioutil.ReadAll(chB)
+		fatal(err, t)
+		done <- b
+	}()
+	writes := atomic.LoadInt64(&conn.writes)
+	for i := 0; i < 100; i++ {
+		_, err := chA.Write([]byte("0123456789"))
+		fatal(err, t)
+	}
+	// the close is batched too, and written out by closing the session
+	fatal(chA.Close(), t)
+	fatal(sessA.Close(), t)
+
+	if got := <-done; !bytes.Equal(got, bytes.Repeat([]byte("0123456789"), 100)) {
+		t.Fatalf("unexpected data: %q", got)
+	}
+	if n := atomic.LoadInt64(&conn.writes) - writes; n > 10 {
+		t.Fatalf("101 frames took %d writes", n)
+	}
+}
+
+// BenchmarkWriteBatching makes small round trips on many channels at once,
+// reporting how many transport writes each one takes with and without
+// write batching.
+func BenchmarkWriteBatching(b *testing.B) {
+	for _, tr := range benchTransports {
+		for _, bm := range []struct {
+			name  string
+			delay time.Duration
+		}{
+			{"unbatched", 0},
+			{"batched", 100 * time.Microsecond},
+		} {
+			b.Run(tr.name+"/"+bm.name, func(b *testing.B) {
+				a, c := tr.pipe(b)
+				conn := &countConn{Conn: a}
+				cfg := Config{WriteBatchDelay: bm.delay}
+				sessA := NewWithConfig(conn, cfg)
+				sessB := NewWithConfig(c, cfg)
+				defer sessA.Close()
+				defer sessB.Close()
+
+				go func() {
+					for {
+						ch, err := sessB.Accept()
+						if err != nil {
+							return
+						}
+						go io.Copy(ch, ch)
+					}
+				}()
+
+				b.SetParallelism(16)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					ch, err := sessA.Open(context.Background())
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					defer ch.Close()
+					msg := make([]byte, 64)
+					for pb.Next() {
+						if _, err := ch.Write(msg); err != nil {
+							b.Error(err)
+							return
+						}
+						if _, err := io.ReadFull(ch, msg); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+				b.StopTimer()
+				b.ReportMetric(float64(atomic.LoadInt64(&conn.writes))/float64(b.N), "writes/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package mux
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux/frame"
+)
+
+// A StatsReporter is a Session that reports statistics about itself
+// and its open channels.
+type StatsReporter interface {
+	Stats() SessionStats
+}
+
+// A ChannelStatsReporter is a Channel that reports statistics about itself.
+type ChannelStatsReporter interface {
+	Stats() ChannelStats
+}
+
+// SessionStats is a snapshot of session statistics.
+type SessionStats struct {
+	// OpenChannels is the number of channels currently open.
+	OpenChannels int
+
+	// Opened is the number of channels opened by this end and
+	// confirmed by the other end.
+	Opened uint64
+
+	// Accepted is the number of channels opened by the other end
+	// and confirmed by this end.
+	Accepted uint64
+
+	// OpenFailed is the number of channels opened by this end
+	// that the other end refused.
+	OpenFailed uint64
+
+	// Rejected is the number of channels opened by the other end
+	// that this end refused.
+	Rejected uint64
+
+	// BytesSent and BytesReceived are the number of bytes written
+	// to and read from the transport, including framing.
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// FramesSent and FramesReceived count frames by frame type,
+	// such as "Data" or "WindowAdjust".
+	FramesSent     map[string]FrameStats
+	FramesReceived map[string]FrameStats
+
+	// FlowControlBlocked is the total time writers on any channel
+	// have spent waiting for the other end to grow the window.
+	FlowControlBlocked time.Duration
+
+	// Channels holds the statistics of each open channel.
+	Channels []ChannelStats
+}
+
+// FrameStats counts frames of a single type.
+type FrameStats struct {
+	Frames uint64
+	Bytes  uint64
+}
+
+// ChannelStats is a snapshot of channel statistics.
+type ChannelStats struct {
+	// ID is the local ID of the channel.
+	ID uint32
+
+	// BytesSent and BytesReceived are the number of data bytes
+	// written to and received on the channel.
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// LocalWindow is how much more data the other end may send
+	// before it has to wait for this end to read.
+	LocalWindow uint32
+
+	// WindowSize is the size of the local window, which grows
+	// from Config.WindowSize when auto-tuning is enabled.
+	WindowSize uint32
+
+	// RemoteWindow is how much more data this end may send before
+	// it has to wait for the other end to read.
+	RemoteWindow uint32
+
+	// FlowControlBlocked is the total time writers have spent waiting
+	// for the other end to grow the window.
+	FlowControlBlocked time.Duration
+
+	// Pending is the number of bytes received but not yet read.
+	Pending int
+}
+
+// sessionStats collects the statistics of a session.
+type sessionStats struct {
+	opened     uint64
+	accepted   uint64
+	openFailed uint64
+	rejected   uint64
+
+	// mu protects the fields below.
+	mu       sync.Mutex
+	sent     map[string]FrameStats
+	received map[string]FrameStats
+	// blocked is the time spent blocked by channels
+	// that have since been closed.
+	blocked time.Duration
+}
+
+func (st *sessionStats) frameSent(name string, size int) {
+	st.mu.Lock()
+	st.sent = countFrame(st.sent, name, size)
+	st.mu.Unlock()
+}
+
+func (st *sessionStats) frameReceived(name string, size int) {
+	st.mu.Lock()
+	st.received = countFrame(st.received, name, size)
+	st.mu.Unlock()
+}
+
+func (st *sessionStats) addBlocked(d time.Duration) {
+	st.mu.Lock()
+	st.blocked += d
+	st.mu.Unlock()
+}
+
+func countFrame(m map[string]FrameStats, name string, size int) map[string]FrameStats {
+	if m == nil {
+		m = make(map[string]FrameStats)
+	}
+	fs := m[name]
+	fs.Frames++
+	fs.Bytes += uint64(size)
+	m[name] = fs
+	return m
+}
+
+// frameType returns the name of the frame type of msg,
+// which is its type name without the Message suffix.
+func frameType(msg frame.Message) string {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.TrimSuffix(t.Name(), "Message")
+}
+
+// frameSize returns the encoded size of msg.
+func frameSize(msg frame.Message) int {
+	// avoid copying the payload just to measure it
+	switch m := msg.(type) {
+	case frame.DataMessage:
+		return 9 + len(m.Data)
+	case *frame.DataMessage:
+		return 9 + len(m.Data)
+	}
+	return len(msg.Bytes())
+}
+
+func copyFrameStats(m map[string]FrameStats) map[string]FrameStats {
+	c := make(map[string]FrameStats, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// Stats returns a snapshot of the session statistics.
+func (s *session) Stats() SessionStats {
+	chans := s.chans.list()
+	stats := SessionStats{
+		OpenChannels: len(chans),
+		Opened:       atomic.LoadUint64(&s.stats.opened),
+		Accepted:     atomic.LoadUint64(&s.stats.accepted),
+		OpenFailed:   atomic.LoadUint64(&s.stats.openFailed),
+		Rejected:     atomic.LoadUint64(&s.stats.rejected),
+	}
+
+	s.stats.mu.Lock()
+	stats.FramesSent = copyFrameStats(s.stats.sent)
+	stats.FramesReceived = copyFrameStats(s.stats.received)
+	stats.FlowControlBlocked = s.stats.blocked
+	s.stats.mu.Unlock()
+
+	for _, fs := range stats.FramesSent {
+		stats.BytesSent += fs.Bytes
+	}
+	for _, fs := range stats.FramesReceived {
+		stats.BytesReceived += fs.Bytes
+	}
+	for _, ch := range chans {
+		cs := ch.Stats()
+		stats.FlowControlBlocked += cs.FlowControlBlocked
+		stats.Channels = append(stats.Channels, cs)
+	}
+	return stats
+}
+
+// Stats returns a snapshot of the channel statistics.
+func (ch *channel) Stats() ChannelStats {
+	stats := ChannelStats{
+		ID:            ch.localId,
+		BytesSent:     atomic.LoadUint64(&ch.bytesSent),
+		BytesReceived: atomic.LoadUint64(&ch.bytesReceived),
+		Pending:       ch.pending.len(),
+	}
+
+	ch.windowMu.Lock()
+	stats.LocalWindow = ch.myWindow
+	stats.WindowSize = ch.winSize
+	ch.windowMu.Unlock()
+
+	ch.remoteWin.L.Lock()
+	stats.RemoteWindow = ch.remoteWin.win
+	stats.FlowControlBlocked = ch.remoteWin.blocked
+	ch.remoteWin.L.Unlock()
+
+	return stats
+}
@@ -0,0 +1,32 @@
+package mux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+)
+
+// A TLSSession is a Session that may run over a TLS connection.
+type TLSSession interface {
+	// PeerCertificates returns the certificate chain presented by the other
+	// end, which is empty if the session is not running over TLS or the
+	// other end did not present a certificate.
+	PeerCertificates() []*x509.Certificate
+}
+
+// connectionStater is implemented by transports that run over TLS,
+// such as *tls.Conn.
+type connectionStater interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// tlsTransport is a transport layered over a TLS connection that
+// doesn't itself expose the TLS connection state, like a WebSocket.
+type tlsTransport struct {
+	io.ReadWriteCloser
+	state func() tls.ConnectionState
+}
+
+func (t *tlsTransport) ConnectionState() tls.ConnectionState {
+	return t.state()
+}
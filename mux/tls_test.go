@@ -0,0 +1,135 @@
+package mux
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestCert returns a self-signed certificate for 127.0.0.1
+// usable by both TLS servers and clients.
+func newTestCert(t *testing.T, name string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	fatal(err, t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	fatal(err, t)
+	cert, err := x509.ParseCertificate(der)
+	fatal(err, t)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+// newTestTLSConfigs returns server and client configs for mutual TLS.
+func newTestTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	serverCert, serverPool := newTestCert(t, "server")
+	clientCert, clientPool := newTestCert(t, "client")
+	server := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	}
+	client := &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      serverPool,
+	}
+	return server, client
+}
+
+func TestTLS(t *testing.T) {
+	serverConfig, clientConfig := newTestTLSConfigs(t)
+	l, err := ListenTLS("127.0.0.1:0", serverConfig)
+	fatal(err, t)
+	startListener(t, l)
+
+	sess, err := DialTLS(l.Addr().String(), clientConfig)
+	fatal(err, t)
+	testExchange(t, sess)
+}
+
+func TestWSS(t *testing.T) {
+	serverConfig, clientConfig := newTestTLSConfigs(t)
+	l, err := ListenWSS("127.0.0.1:0", serverConfig)
+	fatal(err, t)
+	startListener(t, l)
+
+	sess, err := DialWSS(l.Addr().String(), clientConfig)
+	fatal(err, t)
+	testExchange(t, sess)
+}
+
+func TestPeerCertificates(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		listen func(addr string, config *tls.Config) (Listener, error)
+		dial   func(addr string, config *tls.Config) (Session, error)
+	}{
+		{"tls", ListenTLS, DialTLS},
+		{"wss", ListenWSS, DialWSS},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig, clientConfig := newTestTLSConfigs(t)
+			l, err := tt.listen("127.0.0.1:0", serverConfig)
+			fatal(err, t)
+			defer l.Close()
+
+			accepted := make(chan Session, 1)
+			go func() {
+				sess, err := l.Accept()
+				fatal(err, t)
+				accepted <- sess
+			}()
+
+			client, err := tt.dial(l.Addr().String(), clientConfig)
+			fatal(err, t)
+			defer client.Close()
+			go client.Open(context.Background())
+
+			server := <-accepted
+			defer server.Close()
+			_, err = server.Accept()
+			fatal(err, t)
+
+			for _, c := range []struct {
+				sess Session
+				want string
+			}{
+				{client, "server"},
+				{server, "client"},
+			} {
+				certs := c.sess.(TLSSession).PeerCertificates()
+				if len(certs) == 0 || certs[0].Subject.CommonName != c.want {
+					t.Fatalf("unexpected peer certificates: %v", certs)
+				}
+			}
+		})
+	}
+
+	t.Run("tcp", func(t *testing.T) {
+		sessA, _ := newPipeSessions(t)
+		if certs := sessA.(TLSSession).PeerCertificates(); certs != nil {
+			t.Fatalf("unexpected peer certificates: %v", certs)
+		}
+	})
+}
@@ -98,6 +98,20 @@ func TestTCP(t *testing.T) {
 	testExchange(t, sess)
 }
 
+func TestTCPWithConfig(t *testing.T) {
+	cfg := Config{
+		MaxPacketSize: 1 << 10,
+		WindowSize:    1 << 16,
+	}
+	l, err := ListenTCPWithConfig("127.0.0.1:0", cfg)
+	fatal(err, t)
+	startListener(t, l)
+
+	sess, err := DialTCPWithConfig(l.Addr().String(), cfg)
+	fatal(err, t)
+	testExchange(t, sess)
+}
+
 func TestUnix(t *testing.T) {
 	tmp := t.TempDir()
 	sockPath := path.Join(tmp, "qmux.sock")
@@ -2,7 +2,9 @@ package mux
 
 import (
 	"io"
+	"os"
 	"sync"
+	"time"
 )
 
 // buffer provides a linked list buffer for data exchange
@@ -16,12 +18,37 @@ type buffer struct {
 	tail *element // the buffer that will be read last
 
 	closed bool
+	// err is returned by Read once the buffer is reset.
+	err error
+
+	// size is the number of bytes written but not yet read
+	size int
+
+	deadline deadline
+
+	// direct is offered by a Read waiting on the empty buffer, so the
+	// next data frame can be copied into its destination once it has
+	// been buffered, rather than through a pooled buffer.
+	// directOwned is set while a Read is using it.
+	direct      directRead
+	directOwned bool
 }
 
 // An element represents a single link in a linked list.
 type element struct {
-	buf  []byte
-	next *element
+	buf []byte
+	// pooled is the payload buffer buf was read into, given back
+	// to the pools once buf has been read.
+	pooled *[]byte
+	next   *element
+}
+
+// directRead is the destination of a Read waiting for data.
+type directRead struct {
+	p       []byte
+	waiting bool // p can be claimed
+	filling bool // p has been claimed and is being read into
+	n       int  // bytes read into p once filled
 }
 
 // newBuffer returns an empty buffer that is not closed.
@@ -35,13 +62,56 @@ func newBuffer() *buffer {
 	return b
 }
 
-// write makes buf available for Read to receive.
-// buf must not be modified after the call to write.
-func (b *buffer) write(buf []byte) {
+// claim returns the destination of a waiting Read to read n bytes into
+// directly, or nil if there is none large enough. The claim must then
+// be completed with write or release.
+func (b *buffer) claim(n int) []byte {
+	b.Cond.L.Lock()
+	defer b.Cond.L.Unlock()
+	if n == 0 || !b.direct.waiting || len(b.direct.p) < n || b.size > 0 || b.closed {
+		return nil
+	}
+	b.direct.waiting = false
+	b.direct.filling = true
+	return b.direct.p[:n]
+}
+
+// release completes a claim that wasn't written, so the Read
+// that was claimed goes back to waiting.
+func (b *buffer) release() {
+	b.Cond.L.Lock()
+	if b.direct.filling {
+		b.direct.filling = false
+		b.Cond.Broadcast()
+	}
+	b.Cond.L.Unlock()
+}
+
+// write makes buf available for Read to receive. If buf was claimed
+// from a waiting Read, it is handed to that Read. Otherwise, buf must
+// not be modified after the call to write, and pooled, if set, is given
+// back to the pools once buf has been read.
+func (b *buffer) write(buf []byte, pooled *[]byte) {
 	b.Cond.L.Lock()
-	e := &element{buf: buf}
+	if b.direct.filling {
+		b.direct.filling = false
+		b.direct.n = len(buf)
+		b.Cond.Broadcast()
+		b.Cond.L.Unlock()
+		return
+	}
+	if b.err != nil {
+		// discarded once the buffer is reset
+		if pooled != nil {
+			putPayload(pooled)
+		}
+		b.Cond.L.Unlock()
+		return
+	}
+	e := &element{buf: buf, pooled: pooled}
 	b.tail.next = e
 	b.tail = e
+	b.size += len(buf)
 	b.Cond.Signal()
 	b.Cond.L.Unlock()
 }
@@ -55,18 +125,89 @@ func (b *buffer) eof() {
 	b.Cond.L.Unlock()
 }
 
+// reset closes the buffer and discards the data in it,
+// so Reads fail with err right away.
+func (b *buffer) reset(err error) {
+	b.Cond.L.Lock()
+	defer b.Cond.L.Unlock()
+	b.closed = true
+	if b.err == nil {
+		b.err = err
+	}
+	for e := b.head; e != nil; e = e.next {
+		if e.pooled != nil {
+			putPayload(e.pooled)
+		}
+	}
+	e := new(element)
+	b.head, b.tail, b.size = e, e, 0
+	b.Cond.Broadcast()
+}
+
+// len returns the number of bytes available to Read.
+func (b *buffer) len() int {
+	b.Cond.L.Lock()
+	defer b.Cond.L.Unlock()
+	return b.size
+}
+
+// setDeadline sets the time after which blocked and future Reads
+// fail with os.ErrDeadlineExceeded. A zero value for t means Read
+// will not time out.
+func (b *buffer) setDeadline(t time.Time) {
+	b.Cond.L.Lock()
+	b.deadline.set(t, b.Cond)
+	b.Cond.L.Unlock()
+}
+
 // Read reads data from the internal buffer in buf.  Reads will block
-// if no data is available, or until the buffer is closed.
+// if no data is available, or until the buffer is closed or the
+// deadline passes.
 func (b *buffer) Read(buf []byte) (n int, err error) {
 	b.Cond.L.Lock()
 	defer b.Cond.L.Unlock()
 
+	if b.deadline.exceeded() {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	// whether this Read owns b.direct
+	direct := false
+	defer func() {
+		if direct {
+			b.direct = directRead{}
+			b.directOwned = false
+		}
+	}()
+
 	for len(buf) > 0 {
+		if direct {
+			// a data frame is being copied into buf, which is only
+			// done once it has been buffered, so it finishes right away
+			if b.direct.filling {
+				b.Cond.Wait()
+				continue
+			}
+			if b.direct.n > 0 && b.err == nil {
+				n = b.direct.n
+				break
+			}
+		}
+		if b.err != nil {
+			n, err = 0, b.err
+			break
+		}
+
 		// if there is data in b.head, copy it
 		if len(b.head.buf) > 0 {
 			r := copy(buf, b.head.buf)
 			buf, b.head.buf = buf[r:], b.head.buf[r:]
 			n += r
+			b.size -= r
+			if len(b.head.buf) == 0 && b.head.pooled != nil {
+				putPayload(b.head.pooled)
+				b.head.buf, b.head.pooled = nil, nil
+			}
 			continue
 		}
 		// if there is a next buffer, make it the head
@@ -86,7 +227,19 @@ func (b *buffer) Read(buf []byte) (n int, err error) {
 			err = io.EOF
 			break
 		}
-		// out of buffers, wait for producer
+		if b.deadline.exceeded() {
+			err = os.ErrDeadlineExceeded
+			break
+		}
+		// out of buffers, wait for producer, offering buf
+		// to read the next data frame into
+		if !b.directOwned {
+			b.directOwned, direct = true, true
+		}
+		if direct {
+			b.direct.p = buf
+			b.direct.waiting = true
+		}
 		b.Cond.Wait()
 	}
 	return
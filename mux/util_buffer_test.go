@@ -0,0 +1,118 @@
+package mux
+
+import (
+	"testing"
+	"time"
+)
+
+// waitForRead waits until a Read is waiting on b.
+func waitForRead(b *buffer) {
+	for {
+		b.L.Lock()
+		waiting := b.direct.waiting
+		b.L.Unlock()
+		if waiting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBufferDirectRead(t *testing.T) {
+	b := newBuffer()
+	type result struct {
+		n   int
+		err error
+	}
+	dst := make([]byte, 16)
+	read := make(chan result, 1)
+	go func() {
+		n, err := b.Read(dst)
+		read <- result{n, err}
+	}()
+	waitForRead(b)
+
+	// a claim too large for the Read is refused
+	if p := b.claim(32); p != nil {
+		t.Fatal("claimed more than the Read has room for")
+	}
+
+	// a released claim leaves the Read waiting for the next
+	p := b.claim(5)
+	if p == nil {
+		t.Fatal("waiting Read was not claimed")
+	}
+	b.release()
+	waitForRead(b)
+
+	p = b.claim(5)
+	copy(p, "hello")
+	b.write(p, nil)
+	r := <-read
+	fatal(r.err, t)
+	if string(dst[:r.n]) != "hello" {
+		t.Fatalf("unexpected read: %q", dst[:r.n])
+	}
+	if b.len() != 0 {
+		t.Fatal("direct read was also buffered")
+	}
+
+	// queued data is read before anything is claimed
+	b.write([]byte("queued"), nil)
+	if p := b.claim(5); p != nil {
+		t.Fatal("claimed a Read with data still queued")
+	}
+	n, err := b.Read(dst)
+	fatal(err, t)
+	if string(dst[:n]) != "queued" {
+		t.Fatalf("unexpected read: %q", dst[:n])
+	}
+}
+
+func TestBufferPooledRead(t *testing.T) {
+	b := newBuffer()
+	p := getPayload(1000)
+	copy(*p, "hello")
+	b.write((*p)[:5], p)
+
+	dst := make([]byte, 3)
+	n, err := b.Read(dst)
+	fatal(err, t)
+	if string(dst[:n]) != "hel" || b.head.pooled == nil {
+		t.Fatal("payload given back before it was read")
+	}
+	n, err = b.Read(dst)
+	fatal(err, t)
+	if string(dst[:n]) != "lo" || b.head.pooled != nil {
+		t.Fatal("payload not given back once read")
+	}
+}
+
+func TestBufferReset(t *testing.T) {
+	b := newBuffer()
+	errReset := &ResetError{Code: 1}
+	b.write([]byte("discarded"), nil)
+	b.reset(errReset)
+	if n, err := b.Read(make([]byte, 16)); n != 0 || err != errReset {
+		t.Fatalf("unexpected read after reset: %d, %v", n, err)
+	}
+	b.write([]byte("dropped"), nil)
+	if b.len() != 0 {
+		t.Fatal("data was buffered after reset")
+	}
+
+	// a Read claimed for a data frame fails once the frame is in
+	b = newBuffer()
+	read := make(chan error, 1)
+	go func() {
+		_, err := b.Read(make([]byte, 16))
+		read <- err
+	}()
+	waitForRead(b)
+	p := b.claim(5)
+	b.reset(errReset)
+	b.write(p, nil)
+	if err := <-read; err != errReset {
+		t.Fatalf("expected claimed read to fail with the reset, but got: %v", err)
+	}
+}
@@ -10,6 +10,13 @@ type chanList struct {
 	// chans are indexed by the local id of the channel, which the
 	// other side should send in the PeersId field.
 	chans []*channel
+
+	// n is the number of channels in the list, not counting
+	// detached channels.
+	n        int
+	detached map[uint32]bool
+	// empty is closed when the list next becomes empty.
+	empty chan struct{}
 }
 
 // Assigns a channel ID to the given channel.
@@ -19,10 +26,12 @@ func (c *chanList) add(ch *channel) uint32 {
 	for i := range c.chans {
 		if c.chans[i] == nil {
 			c.chans[i] = ch
+			c.n++
 			return uint32(i)
 		}
 	}
 	c.chans = append(c.chans, ch)
+	c.n++
 	return uint32(len(c.chans) - 1)
 }
 
@@ -36,12 +45,79 @@ func (c *chanList) getChan(id uint32) *channel {
 	return nil
 }
 
-func (c *chanList) remove(id uint32) {
+// remove forgets the channel with the given ID, returning
+// whether there was one.
+func (c *chanList) remove(id uint32) bool {
 	c.Lock()
-	if id < uint32(len(c.chans)) {
+	defer c.Unlock()
+	if id < uint32(len(c.chans)) && c.chans[id] != nil {
 		c.chans[id] = nil
+		if c.detached[id] {
+			delete(c.detached, id)
+			return true
+		}
+		c.n--
+		c.signalEmpty()
+		return true
+	}
+	return false
+}
+
+// detach stops counting the channel with the given ID, so the list can be
+// emptied while the channel waits on the other end. Its ID isn't reused
+// until it is removed.
+func (c *chanList) detach(id uint32) {
+	c.Lock()
+	defer c.Unlock()
+	if id < uint32(len(c.chans)) && c.chans[id] != nil && !c.detached[id] {
+		if c.detached == nil {
+			c.detached = make(map[uint32]bool)
+		}
+		c.detached[id] = true
+		c.n--
+		c.signalEmpty()
+	}
+}
+
+// len returns the number of channels in the list.
+func (c *chanList) len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.n
+}
+
+// emptied returns a channel that is closed once the list has no channels.
+func (c *chanList) emptied() <-chan struct{} {
+	c.Lock()
+	defer c.Unlock()
+	if c.empty == nil {
+		c.empty = make(chan struct{})
 	}
-	c.Unlock()
+	ch := c.empty
+	c.signalEmpty()
+	return ch
+}
+
+// signalEmpty closes the empty channel if the list is empty.
+// It must be called with the lock held.
+func (c *chanList) signalEmpty() {
+	if c.n == 0 && c.empty != nil {
+		close(c.empty)
+		c.empty = nil
+	}
+}
+
+// list returns the channels in the list.
+func (c *chanList) list() []*channel {
+	c.Lock()
+	defer c.Unlock()
+	var r []*channel
+	for id, ch := range c.chans {
+		if ch != nil && !c.detached[uint32(id)] {
+			r = append(r, ch)
+		}
+	}
+	return r
 }
 
 // dropAll forgets all channels it knows, returning them in a slice.
@@ -57,5 +133,8 @@ func (c *chanList) dropAll() []*channel {
 		r = append(r, ch)
 	}
 	c.chans = nil
+	c.n = 0
+	c.detached = nil
+	c.signalEmpty()
 	return r
 }
@@ -0,0 +1,52 @@
+package mux
+
+import "testing"
+
+func TestChanListEmptied(t *testing.T) {
+	var c chanList
+	select {
+	case <-c.emptied():
+	default:
+		t.Fatal("empty list was not reported empty")
+	}
+
+	a := c.add(&channel{})
+	b := c.add(&channel{})
+	emptied := c.emptied()
+	c.remove(a)
+	select {
+	case <-emptied:
+		t.Fatal("list reported empty with a channel left")
+	default:
+	}
+	c.remove(b)
+	select {
+	case <-emptied:
+	default:
+		t.Fatal("list was not reported empty after removing its channels")
+	}
+	if n := c.len(); n != 0 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+}
+
+func TestChanListDetach(t *testing.T) {
+	var c chanList
+	a := c.add(&channel{})
+	emptied := c.emptied()
+	c.detach(a)
+	select {
+	case <-emptied:
+	default:
+		t.Fatal("list with only a detached channel was not reported empty")
+	}
+	if b := c.add(&channel{}); b == a {
+		t.Fatal("detached channel id was reused")
+	}
+	if c.getChan(a) == nil || len(c.list()) != 1 || c.len() != 1 {
+		t.Fatalf("unexpected list after detach: %d channels", c.len())
+	}
+	if !c.remove(a) || c.len() != 1 {
+		t.Fatalf("unexpected length after removing detached channel: %d", c.len())
+	}
+}
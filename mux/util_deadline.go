@@ -0,0 +1,38 @@
+package mux
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline tracks a point in time after which blocked operations waiting
+// on a sync.Cond should give up. All methods must be called with the lock
+// of the associated sync.Cond held.
+type deadline struct {
+	t     time.Time
+	timer *time.Timer
+}
+
+// set sets the deadline to t, waking up the waiters on cond when it passes.
+// A zero value for t means there is no deadline.
+func (d *deadline) set(t time.Time, cond *sync.Cond) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.t = t
+	if !t.IsZero() {
+		d.timer = time.AfterFunc(time.Until(t), func() {
+			cond.L.Lock()
+			cond.Broadcast()
+			cond.L.Unlock()
+		})
+	}
+	// wake up waiters to check the new deadline
+	cond.Broadcast()
+}
+
+// exceeded returns true if the deadline has passed.
+func (d *deadline) exceeded() bool {
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
@@ -0,0 +1,46 @@
+package mux
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	// minPooledShift and maxPooledShift bound the capacities, as powers of
+	// two, of the buffers data frames are read into that are recycled once
+	// they have been read.
+	minPooledShift = 9
+	maxPooledShift = 20
+)
+
+// payloadPools holds recycled payload buffers, one pool for
+// each power of two capacity.
+var payloadPools [maxPooledShift - minPooledShift + 1]sync.Pool
+
+// getPayload returns a buffer of length n from the pools, or nil if n is
+// too large to pool. It should be given back with putPayload once read.
+func getPayload(n int) *[]byte {
+	if n == 0 || n > 1<<maxPooledShift {
+		return nil
+	}
+	shift := bits.Len(uint(n - 1))
+	if shift < minPooledShift {
+		shift = minPooledShift
+	}
+	p, _ := payloadPools[shift-minPooledShift].Get().(*[]byte)
+	if p == nil {
+		b := make([]byte, 1<<shift)
+		p = &b
+	}
+	*p = (*p)[:n]
+	return p
+}
+
+// putPayload gives a buffer from getPayload back to the pools.
+func putPayload(p *[]byte) {
+	shift := bits.Len(uint(cap(*p))) - 1
+	if cap(*p) != 1<<shift || shift < minPooledShift || shift > maxPooledShift {
+		return
+	}
+	payloadPools[shift-minPooledShift].Put(p)
+}
@@ -0,0 +1,30 @@
+package mux
+
+import "testing"
+
+func TestPayloadPool(t *testing.T) {
+	for _, test := range []struct {
+		n   int
+		cap int
+	}{
+		{0, 0},
+		{1, 512},
+		{512, 512},
+		{513, 1024},
+		{32 << 10, 32 << 10},
+		{1 << 20, 1 << 20},
+		{1<<20 + 1, 0},
+	} {
+		p := getPayload(test.n)
+		if test.cap == 0 {
+			if p != nil {
+				t.Fatalf("pooled a %d byte payload", test.n)
+			}
+			continue
+		}
+		if len(*p) != test.n || cap(*p) != test.cap {
+			t.Fatalf("payload for %d bytes has len %d and cap %d", test.n, len(*p), cap(*p))
+		}
+		putPayload(p)
+	}
+}
@@ -2,7 +2,9 @@ package mux
 
 import (
 	"io"
+	"os"
 	"sync"
+	"time"
 )
 
 // window represents the buffer available to clients
@@ -12,6 +14,9 @@ type window struct {
 	win          uint32 // RFC 4254 5.2 says the window size can grow to 2^32-1
 	writeWaiters int
 	closed       bool
+	err          error // returned by reserve once reset
+	deadline     deadline
+	blocked      time.Duration // total time spent waiting in reserve
 }
 
 // add adds win to the amount of window available
@@ -44,18 +49,50 @@ func (w *window) close() {
 	w.L.Unlock()
 }
 
+// reset closes the window, so all reservations fail with err.
+func (w *window) reset(err error) {
+	w.L.Lock()
+	w.closed = true
+	if w.err == nil {
+		w.err = err
+	}
+	w.Broadcast()
+	w.L.Unlock()
+}
+
+// setDeadline sets the time after which blocked and future
+// reservations fail with os.ErrDeadlineExceeded. A zero value
+// for t means reserve will not time out.
+func (w *window) setDeadline(t time.Time) {
+	w.L.Lock()
+	w.deadline.set(t, w.Cond)
+	w.L.Unlock()
+}
+
 // reserve reserves win from the available window capacity.
-// If no capacity remains, reserve will block. reserve may
-// return less than requested.
+// If no capacity remains, reserve will block until the deadline.
+// reserve may return less than requested.
 func (w *window) reserve(win uint32) (uint32, error) {
 	var err error
 	w.L.Lock()
 	w.writeWaiters++
 	w.Broadcast()
-	for w.win == 0 && !w.closed {
-		w.Wait()
+	if w.win == 0 && !w.closed && !w.deadline.exceeded() {
+		start := time.Now()
+		for w.win == 0 && !w.closed && !w.deadline.exceeded() {
+			w.Wait()
+		}
+		w.blocked += time.Since(start)
 	}
 	w.writeWaiters--
+	if w.err != nil {
+		w.L.Unlock()
+		return 0, w.err
+	}
+	if w.deadline.exceeded() && !w.closed {
+		w.L.Unlock()
+		return 0, os.ErrDeadlineExceeded
+	}
 	if w.win < win {
 		win = w.win
 	}
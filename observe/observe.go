@@ -0,0 +1,64 @@
+// Package observe defines hooks for wiring qmux sessions and RPC calls
+// into metrics and tracing systems.
+package observe
+
+import (
+	"context"
+	"time"
+)
+
+// An Observer is notified of session, channel, frame and RPC call events.
+// Hooks are called synchronously from the session read loop and from
+// callers' goroutines, so they must be safe for concurrent use and should
+// return quickly. Embed Nop to implement only some of the hooks.
+type Observer interface {
+	// SessionStart is called when a session starts.
+	SessionStart()
+
+	// SessionEnd is called when a session ends with the error that
+	// ended it, which is io.EOF for a clean close.
+	SessionEnd(err error)
+
+	// ChannelOpen is called when a channel open is confirmed. Inbound
+	// is true for channels opened by the other end.
+	ChannelOpen(id uint32, inbound bool)
+
+	// ChannelClose is called when an open channel is closed.
+	ChannelClose(id uint32)
+
+	// FrameSent and FrameReceived are called for each frame written to
+	// or read from the transport with its frame type, such as "Data" or
+	// "WindowAdjust", and its encoded size in bytes.
+	FrameSent(frameType string, size int)
+	FrameReceived(frameType string, size int)
+
+	// CallStart is called when an RPC call starts. The returned context
+	// is used for the call and passed to CallFinish, so it can carry a
+	// tracing span.
+	CallStart(ctx context.Context, call CallInfo) context.Context
+
+	// CallFinish is called when an RPC call finishes, with how long it
+	// took and the error it failed with, if any.
+	CallFinish(ctx context.Context, call CallInfo, d time.Duration, err error)
+}
+
+// CallInfo describes an RPC call.
+type CallInfo struct {
+	// Selector is the selector being called.
+	Selector string
+
+	// Server is true when the call is being handled rather than made.
+	Server bool
+}
+
+// Nop is an Observer that does nothing.
+type Nop struct{}
+
+func (Nop) SessionStart()                                              {}
+func (Nop) SessionEnd(error)                                           {}
+func (Nop) ChannelOpen(uint32, bool)                                   {}
+func (Nop) ChannelClose(uint32)                                        {}
+func (Nop) FrameSent(string, int)                                      {}
+func (Nop) FrameReceived(string, int)                                  {}
+func (Nop) CallStart(ctx context.Context, _ CallInfo) context.Context  { return ctx }
+func (Nop) CallFinish(context.Context, CallInfo, time.Duration, error) {}
@@ -0,0 +1,189 @@
+package observe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics is an Observer that collects counters and serves them in the
+// Prometheus text exposition format. It is meant as a reference for
+// wiring up other metrics systems, and can be mounted on a local HTTP
+// server for scraping:
+//
+//	m := observe.NewMetrics()
+//	http.Handle("/metrics", m)
+type Metrics struct {
+	mu             sync.Mutex
+	sessionsActive int64
+	sessionsTotal  uint64
+	channelsActive int64
+	channelsOpened map[string]uint64 // by direction
+	framesSent     map[string]uint64 // by frame type
+	framesReceived map[string]uint64
+	bytesSent      map[string]uint64
+	bytesReceived  map[string]uint64
+	calls          map[callKey]uint64
+	callSeconds    map[callKey]float64
+}
+
+type callKey struct {
+	side     string
+	selector string
+	status   string
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		channelsOpened: make(map[string]uint64),
+		framesSent:     make(map[string]uint64),
+		framesReceived: make(map[string]uint64),
+		bytesSent:      make(map[string]uint64),
+		bytesReceived:  make(map[string]uint64),
+		calls:          make(map[callKey]uint64),
+		callSeconds:    make(map[callKey]float64),
+	}
+}
+
+func (m *Metrics) SessionStart() {
+	m.mu.Lock()
+	m.sessionsActive++
+	m.sessionsTotal++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) SessionEnd(err error) {
+	m.mu.Lock()
+	m.sessionsActive--
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ChannelOpen(id uint32, inbound bool) {
+	direction := "outbound"
+	if inbound {
+		direction = "inbound"
+	}
+	m.mu.Lock()
+	m.channelsActive++
+	m.channelsOpened[direction]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ChannelClose(id uint32) {
+	m.mu.Lock()
+	m.channelsActive--
+	m.mu.Unlock()
+}
+
+func (m *Metrics) FrameSent(frameType string, size int) {
+	m.mu.Lock()
+	m.framesSent[frameType]++
+	m.bytesSent[frameType] += uint64(size)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) FrameReceived(frameType string, size int) {
+	m.mu.Lock()
+	m.framesReceived[frameType]++
+	m.bytesReceived[frameType] += uint64(size)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) CallStart(ctx context.Context, call CallInfo) context.Context {
+	return ctx
+}
+
+func (m *Metrics) CallFinish(ctx context.Context, call CallInfo, d time.Duration, err error) {
+	k := callKey{side: "client", selector: call.Selector, status: "ok"}
+	if call.Server {
+		k.side = "server"
+	}
+	if err != nil {
+		k.status = "error"
+	}
+	m.mu.Lock()
+	m.calls[k]++
+	m.callSeconds[k] += d.Seconds()
+	m.mu.Unlock()
+}
+
+// ServeHTTP writes the collected metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the collected metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	m.mu.Lock()
+	writeHeader(&b, "qtalk_sessions_active", "gauge", "Number of sessions currently running.")
+	fmt.Fprintf(&b, "qtalk_sessions_active %d\n", m.sessionsActive)
+	writeHeader(&b, "qtalk_sessions_total", "counter", "Number of sessions started.")
+	fmt.Fprintf(&b, "qtalk_sessions_total %d\n", m.sessionsTotal)
+	writeHeader(&b, "qtalk_channels_active", "gauge", "Number of channels currently open.")
+	fmt.Fprintf(&b, "qtalk_channels_active %d\n", m.channelsActive)
+	writeCounters(&b, "qtalk_channels_opened_total", "Number of channels opened.", "direction", m.channelsOpened)
+	writeCounters(&b, "qtalk_frames_sent_total", "Number of frames sent.", "type", m.framesSent)
+	writeCounters(&b, "qtalk_frames_received_total", "Number of frames received.", "type", m.framesReceived)
+	writeCounters(&b, "qtalk_frame_bytes_sent_total", "Number of frame bytes sent.", "type", m.bytesSent)
+	writeCounters(&b, "qtalk_frame_bytes_received_total", "Number of frame bytes received.", "type", m.bytesReceived)
+
+	keys := make([]callKey, 0, len(m.calls))
+	for k := range m.calls {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.side != b.side {
+			return a.side < b.side
+		}
+		if a.selector != b.selector {
+			return a.selector < b.selector
+		}
+		return a.status < b.status
+	})
+	writeHeader(&b, "qtalk_rpc_call_duration_seconds", "summary", "Duration of RPC calls.")
+	for _, k := range keys {
+		labels := fmt.Sprintf("side=%q,selector=%q,status=%q", k.side, escapeLabel(k.selector), k.status)
+		fmt.Fprintf(&b, "qtalk_rpc_call_duration_seconds_sum{%s} %g\n", labels, m.callSeconds[k])
+		fmt.Fprintf(&b, "qtalk_rpc_call_duration_seconds_count{%s} %d\n", labels, m.calls[k])
+	}
+	m.mu.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounters(b *strings.Builder, name, help, label string, values map[string]uint64) {
+	writeHeader(b, name, "counter", help)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{%s=%q} %d\n", name, label, escapeLabel(k), values[k])
+	}
+}
+
+// escapeLabel drops characters %q would escape differently
+// than the Prometheus text format allows.
+func escapeLabel(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,57 @@
+package observe_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/progrium/qtalk-go/mux"
+	"github.com/progrium/qtalk-go/observe"
+)
+
+func TestMetrics(t *testing.T) {
+	m := observe.NewMetrics()
+	a, b := net.Pipe()
+	sessA := mux.NewWithConfig(a, mux.Config{Observer: m})
+	sessB := mux.New(b)
+
+	go func() {
+		ch, err := sessB.Accept()
+		if err != nil {
+			return
+		}
+		ioutil.ReadAll(ch)
+		ch.Close()
+	}()
+
+	ch, err := sessA.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	ch.Close()
+	sessA.Close()
+	sessA.Wait()
+	sessB.Close()
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE qtalk_sessions_total counter",
+		"qtalk_sessions_total 1",
+		"qtalk_sessions_active 0",
+		`qtalk_channels_opened_total{direction="outbound"} 1`,
+		`qtalk_frames_sent_total{type="Data"} 1`,
+		`qtalk_frame_bytes_sent_total{type="Data"} 14`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("missing metric %q in:\n%s", line, body)
+		}
+	}
+}
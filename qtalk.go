@@ -4,6 +4,9 @@ import (
 	_ "github.com/progrium/qtalk-go/codec"
 	_ "github.com/progrium/qtalk-go/fn"
 	_ "github.com/progrium/qtalk-go/mux"
+	_ "github.com/progrium/qtalk-go/mux/noise"
+	_ "github.com/progrium/qtalk-go/mux/resume"
+	_ "github.com/progrium/qtalk-go/observe"
 	_ "github.com/progrium/qtalk-go/rpc"
 	_ "github.com/progrium/qtalk-go/talk"
 )
@@ -3,9 +3,12 @@ package rpc
 import (
 	"context"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/progrium/qtalk-go/codec"
 	"github.com/progrium/qtalk-go/mux"
+	"github.com/progrium/qtalk-go/observe"
 )
 
 // RemoteError is an error that has been returned from
@@ -19,6 +22,17 @@ func (e RemoteError) Error() string {
 // Client wraps a session and codec to make RPC calls over the session.
 type Client struct {
 	mux.Session
+
+	// Observer, if set, is notified when calls start and finish.
+	Observer observe.Observer
+
+	// Interceptors are run around each call, the first one outermost.
+	Interceptors []ClientInterceptor
+
+	// MaxFrameSize is the largest frame accepted in responses. It defaults
+	// to DefaultMaxFrameSize.
+	MaxFrameSize uint32
+
 	codec codec.Codec
 }
 
@@ -35,59 +49,101 @@ func NewClient(session mux.Session, codec codec.Codec) *Client {
 // values for asynchronously streaming multiple values from another goroutine, however
 // the call will still block until a response is sent. If there is an error making the call
 // an error is returned, and if an error is returned by the remote handler a RemoteError
-// is returned.
+// is returned, or a *RemoteCodeError wrapping one if the error has a code or details.
 //
 // A Response value is also returned for advanced operations. For example, you can check
 // if the call is continued, meaning the underlying channel will be kept open for either
 // streaming back more results or using the channel as a full duplex byte stream.
-func (c *Client) Call(ctx context.Context, selector string, args any, replies ...any) (*Response, error) {
+func (c *Client) Call(ctx context.Context, selector string, args any, replies ...any) (resp *Response, err error) {
+	if c.Observer != nil {
+		info := observe.CallInfo{Selector: selector}
+		ctx = c.Observer.CallStart(ctx, info)
+		start := time.Now()
+		defer func() {
+			c.Observer.CallFinish(ctx, info, time.Since(start), err)
+		}()
+	}
+
+	if len(c.Interceptors) > 0 {
+		return Intercept(callerFunc(c.invoke), c.Interceptors...).Call(ctx, selector, args, replies...)
+	}
+	return c.invoke(ctx, selector, args, replies...)
+}
+
+// invoke makes the call without running interceptors.
+func (c *Client) invoke(ctx context.Context, selector string, args any, replies ...any) (*Response, error) {
 	ch, err := c.Session.Open(ctx)
 	if err != nil {
 		return nil, err
 	}
-	// If the context is cancelled before the call completes, call Close() to
-	// abort the current operation.
+	// If the context is cancelled before the call completes, abort the
+	// current operation.
 	done := make(chan struct{})
 	defer close(done)
 	go func() {
 		select {
 		case <-ctx.Done():
-			ch.Close()
+			abort(ch, ctx.Err())
 		case <-done:
 		}
 	}()
-	resp, err := call(ctx, ch, c.codec, selector, args, replies...)
+	framer := &FrameCodec{Codec: c.codec, MaxFrameSize: c.MaxFrameSize}
+	resp, err := call(ctx, ch, framer, selector, args, replies...)
 	if ctxErr := ctx.Err(); ctxErr != nil {
 		return resp, ctxErr
 	}
 	return resp, err
 }
 
-func call(ctx context.Context, ch mux.Channel, cd codec.Codec, selector string, args any, replies ...any) (*Response, error) {
-	framer := &FrameCodec{Codec: cd}
+// abort ends a call given up on because of err. If ch can be reset, it is
+// reset with the code for err, so the other end can tell the call was
+// abandoned rather than completed. Otherwise, or if the other end doesn't
+// support resets, it is closed.
+func abort(ch mux.Channel, err error) {
+	if r, ok := ch.(mux.Resetter); ok {
+		code, _ := errorCode(err)
+		r.Reset(uint32(code))
+		return
+	}
+	ch.Close()
+}
+
+func call(ctx context.Context, ch mux.Channel, framer *FrameCodec, selector string, args any, replies ...any) (*Response, error) {
 	enc := framer.Encoder(ch)
 	dec := framer.Decoder(ch)
 
 	// request
-	err := enc.Encode(CallHeader{
+	req := CallHeader{
 		Selector: selector,
-	})
+		Metadata: MetadataFromContext(ctx),
+	}
+	if err := req.setTimeout(ctx); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	err := enc.Encode(req)
 	if err != nil {
 		ch.Close()
 		return nil, err
 	}
 
+	// The server may respond and close the channel without reading all
+	// the arguments, in which case writing them fails with io.EOF and the
+	// response is read anyway.
 	argCh, isChan := args.(chan interface{})
 	switch {
 	case isChan:
 		for arg := range argCh {
 			if err := enc.Encode(arg); err != nil {
+				if err == io.EOF {
+					break
+				}
 				ch.Close()
 				return nil, err
 			}
 		}
 	default:
-		if err := enc.Encode(args); err != nil {
+		if err := enc.Encode(args); err != nil && err != io.EOF {
 			ch.Close()
 			return nil, err
 		}
@@ -116,7 +172,7 @@ func call(ctx context.Context, ch mux.Channel, cd codec.Codec, selector string,
 		resp.Reply = replies
 	}
 	if resp.Error != nil {
-		return resp, RemoteError(*resp.Error)
+		return resp, remoteError(&resp.ResponseHeader)
 	}
 
 	if resp.Reply == nil {
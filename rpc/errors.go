@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Code is a well-known error code sent to the caller with an error. Codes
+// are errors themselves, so a call error can be checked for a code with
+// errors.Is:
+//
+//	if errors.Is(err, rpc.NotFound) { ... }
+type Code int
+
+const (
+	Unknown Code = iota
+	Canceled
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	PermissionDenied
+	Unauthenticated
+	Unimplemented
+	Unavailable
+	Internal
+)
+
+var codeNames = map[Code]string{
+	Unknown:          "unknown",
+	Canceled:         "canceled",
+	InvalidArgument:  "invalid argument",
+	DeadlineExceeded: "deadline exceeded",
+	NotFound:         "not found",
+	PermissionDenied: "permission denied",
+	Unauthenticated:  "unauthenticated",
+	Unimplemented:    "unimplemented",
+	Unavailable:      "unavailable",
+	Internal:         "internal",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("code %d", int(c))
+}
+
+func (c Code) Error() string {
+	return c.String()
+}
+
+// Error is an error with a code and optional details that handlers can
+// return to pass them on to the caller. Details are encoded with the
+// server codec.
+type Error struct {
+	Code    Code
+	Message string
+	Details any
+}
+
+// Errorf returns an *Error with the given code and a formatted message.
+func Errorf(code Code, format string, args ...any) error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is the code of e.
+func (e *Error) Is(target error) bool {
+	c, ok := target.(Code)
+	return ok && c == e.Code
+}
+
+// RemoteCodeError is returned by a call in place of a RemoteError when the
+// remote handler returned an error with a code other than Unknown or with
+// details. It wraps the RemoteError, so either can be reached with errors.As.
+type RemoteCodeError struct {
+	RemoteError
+	Code Code
+
+	// Details holds the details of the error as decoded by the client
+	// codec without type information. Use DecodeDetails to decode them
+	// into a typed value.
+	Details any
+}
+
+// Unwrap returns the RemoteError with the message of e.
+func (e *RemoteCodeError) Unwrap() error {
+	return e.RemoteError
+}
+
+// Is reports whether target is the code of e.
+func (e *RemoteCodeError) Is(target error) bool {
+	c, ok := target.(Code)
+	return ok && c == e.Code
+}
+
+// DecodeDetails decodes the error details into the value pointed to by v.
+func (e *RemoteCodeError) DecodeDetails(v any) error {
+	return mapstructure.Decode(e.Details, v)
+}
+
+// remoteError returns the error for a response with an error message.
+func remoteError(header *ResponseHeader) error {
+	err := RemoteError(*header.Error)
+	if header.ErrorCode == Unknown && header.ErrorDetails == nil {
+		return err
+	}
+	return &RemoteCodeError{
+		RemoteError: err,
+		Code:        header.ErrorCode,
+		Details:     header.ErrorDetails,
+	}
+}
+
+// errorCode returns the code and details to send to the caller for err.
+func errorCode(err error) (Code, any) {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Code, rpcErr.Details
+	}
+	var remoteErr *RemoteCodeError
+	if errors.As(err, &remoteErr) {
+		return remoteErr.Code, remoteErr.Details
+	}
+	var code Code
+	if errors.As(err, &code) {
+		return code, nil
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return Canceled, nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded, nil
+	}
+	return Unknown, nil
+}
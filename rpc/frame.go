@@ -3,15 +3,48 @@ package rpc
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"sync"
 
 	"github.com/progrium/qtalk-go/codec"
 )
 
+// DefaultMaxFrameSize is the largest frame a FrameCodec decodes
+// unless its MaxFrameSize is set.
+const DefaultMaxFrameSize = 1 << 24
+
+// ErrFrameTooLarge is returned when decoding a frame larger than
+// the FrameCodec accepts.
+var ErrFrameTooLarge = errors.New("rpc: frame too large")
+
+// maxPooledFrame is the largest frame buffer kept for reuse.
+const maxPooledFrame = 64 << 10
+
+// framePool recycles the buffers frames are encoded to and decoded from.
+var framePool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func putFrame(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledFrame {
+		buf.Reset()
+		framePool.Put(buf)
+	}
+}
+
 // FrameCodec is a special codec used to actually read/write other
 // codecs to a transport using a length prefix.
 type FrameCodec struct {
 	codec.Codec
+
+	// MaxFrameSize is the largest frame its decoders accept. Larger
+	// frames fail with ErrFrameTooLarge before they are read or
+	// allocated. It defaults to DefaultMaxFrameSize if zero.
+	MaxFrameSize uint32
 }
 
 // Encoder returns a frame encoder that first encodes a value
@@ -31,16 +64,19 @@ type frameEncoder struct {
 }
 
 func (e *frameEncoder) Encode(v interface{}) error {
-	var buf bytes.Buffer
-	enc := e.c.Encoder(&buf)
+	// the value is encoded after room for its length prefix,
+	// so the frame can be written in one go
+	buf := framePool.Get().(*bytes.Buffer)
+	defer putFrame(buf)
+	buf.Write([]byte{0, 0, 0, 0})
+	enc := e.c.Encoder(buf)
 	err := enc.Encode(v)
 	if err != nil {
 		return err
 	}
 	b := buf.Bytes()
-	prefix := make([]byte, 4)
-	binary.BigEndian.PutUint32(prefix, uint32(len(b)))
-	_, err = e.w.Write(append(prefix, b...))
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	_, err = e.w.Write(b)
 	if err != nil {
 		return err
 	}
@@ -51,30 +87,45 @@ func (e *frameEncoder) Encode(v interface{}) error {
 // length value used to read the rest of the frame, then uses the
 // embedded codec to decode those bytes into a value.
 func (c *FrameCodec) Decoder(r io.Reader) codec.Decoder {
+	max := c.MaxFrameSize
+	if max == 0 {
+		max = DefaultMaxFrameSize
+	}
 	return &frameDecoder{
-		r: r,
-		c: c.Codec,
+		r:   r,
+		c:   c.Codec,
+		max: max,
 	}
 }
 
 type frameDecoder struct {
-	r io.Reader
-	c codec.Codec
+	r      io.Reader
+	c      codec.Codec
+	max    uint32
+	prefix [4]byte
+	frame  bytes.Reader
 }
 
 func (d *frameDecoder) Decode(v interface{}) error {
-	prefix := make([]byte, 4)
-	_, err := io.ReadFull(d.r, prefix)
+	_, err := io.ReadFull(d.r, d.prefix[:])
 	if err != nil {
 		return err
 	}
-	size := binary.BigEndian.Uint32(prefix)
-	buf := make([]byte, size)
-	_, err = io.ReadFull(d.r, buf)
+	size := binary.BigEndian.Uint32(d.prefix[:])
+	if size > d.max {
+		return fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+	}
+	// the frame is read into the spare capacity of a pooled buffer
+	buf := framePool.Get().(*bytes.Buffer)
+	defer putFrame(buf)
+	buf.Grow(int(size))
+	frame := buf.Bytes()[:size]
+	_, err = io.ReadFull(d.r, frame)
 	if err != nil {
 		return err
 	}
-	dec := d.c.Decoder(bytes.NewBuffer(buf))
+	d.frame.Reset(frame)
+	dec := d.c.Decoder(&d.frame)
 	err = dec.Decode(v)
 	if err != nil {
 		return err
@@ -0,0 +1,126 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/progrium/qtalk-go/codec"
+)
+
+func TestFrameCodecMaxFrameSize(t *testing.T) {
+	// a frame claiming to be 4GB with nothing following
+	var prefix [4]byte
+	binary.BigEndian.PutUint32(prefix[:], 1<<32-1)
+	framer := &FrameCodec{Codec: codec.JSONCodec{}}
+	var v any
+	if err := framer.Decoder(bytes.NewReader(prefix[:])).Decode(&v); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got: %v", err)
+	}
+
+	var buf bytes.Buffer
+	fatal(t, framer.Encoder(&buf).Encode("hello"))
+	framer.MaxFrameSize = uint32(buf.Len() - 5)
+	if err := framer.Decoder(bytes.NewReader(buf.Bytes())).Decode(&v); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got: %v", err)
+	}
+	framer.MaxFrameSize = uint32(buf.Len() - 4)
+	fatal(t, framer.Decoder(bytes.NewReader(buf.Bytes())).Decode(&v))
+	if v != "hello" {
+		t.Fatalf("unexpected value: %v", v)
+	}
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	srv := &Server{
+		Codec: codec.JSONCodec{},
+		Handler: HandlerFunc(func(r Responder, c *Call) {
+			var s string
+			if err := c.Receive(&s); err != nil {
+				r.Return(err)
+				return
+			}
+			r.Return(s)
+		}),
+		MaxFrameSize: 1024,
+	}
+	client := serveTestPair(srv)
+	defer client.Close()
+	ctx := context.Background()
+
+	var reply string
+	_, err := client.Call(ctx, "echo", "hello", &reply)
+	fatal(t, err)
+
+	// the server refuses arguments over its limit
+	_, err = client.Call(ctx, "echo", strings.Repeat("x", 2048), &reply)
+	if err == nil {
+		t.Fatal("expected oversized call to fail")
+	}
+
+	// and the client refuses replies over its own
+	client.MaxFrameSize = 16
+	_, err = client.Call(ctx, "echo", strings.Repeat("x", 512), &reply)
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got: %v", err)
+	}
+}
+
+func FuzzFrameCodec(f *testing.F) {
+	framer := &FrameCodec{Codec: codec.JSONCodec{}, MaxFrameSize: 1 << 16}
+	for _, v := range []any{
+		CallHeader{Selector: "echo", Metadata: Metadata{"id": "1"}},
+		ResponseHeader{Continue: true},
+		"hello",
+		[]any{1.0, "two", nil, map[string]any{"three": true}},
+	} {
+		var buf bytes.Buffer
+		if err := framer.Encoder(&buf).Encode(v); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		dec := framer.Decoder(bytes.NewReader(b))
+		for {
+			var v any
+			if err := dec.Decode(&v); err != nil {
+				return
+			}
+			// anything decoded must survive encoding and decoding again
+			var buf bytes.Buffer
+			if err := framer.Encoder(&buf).Encode(v); err != nil {
+				t.Fatal(err)
+			}
+			var again any
+			if err := framer.Decoder(&buf).Decode(&again); err != nil {
+				t.Fatalf("re-decoding %v: %v", v, err)
+			}
+			if !reflect.DeepEqual(again, v) {
+				t.Fatalf("decoded %v, expected %v", again, v)
+			}
+		}
+	})
+}
+
+func BenchmarkFrameCodec(b *testing.B) {
+	framer := &FrameCodec{Codec: codec.JSONCodec{}}
+	v := ResponseHeader{Continue: true}
+	var buf bytes.Buffer
+	enc := framer.Encoder(&buf)
+	dec := framer.Decoder(&buf)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(v); err != nil {
+			b.Fatal(err)
+		}
+		var header ResponseHeader
+		if err := dec.Decode(&header); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
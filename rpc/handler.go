@@ -1,7 +1,6 @@
 package rpc
 
 import (
-	"fmt"
 	"sort"
 	"strings"
 	"sync"
@@ -30,7 +29,7 @@ func (f HandlerFunc) RespondRPC(resp Responder, call *Call) {
 // NotFoundHandler returns a simple handler that returns an error "not found".
 func NotFoundHandler() Handler {
 	return HandlerFunc(func(r Responder, c *Call) {
-		r.Return(fmt.Errorf("not found: %s", c.Selector))
+		r.Return(Errorf(NotFound, "not found: %s", c.Selector))
 	})
 }
 
@@ -50,6 +49,7 @@ func NotFoundHandler() Handler {
 type RespondMux struct {
 	m  map[string]muxEntry
 	es []muxEntry // slice of entries sorted from longest to shortest.
+	is []ServerInterceptor
 	mu sync.RWMutex
 }
 
@@ -80,7 +80,23 @@ func NewRespondMux() *RespondMux { return new(RespondMux) }
 // RespondRPC dispatches the call to the handler whose pattern most closely matches the selector.
 func (m *RespondMux) RespondRPC(r Responder, c *Call) {
 	h, _ := m.Handler(c)
-	h.RespondRPC(r, c)
+	m.intercept(h).RespondRPC(r, c)
+}
+
+// Use adds interceptors to run around the handlers of the RespondMux, including
+// the "not found" handler. Interceptors added to a RespondMux used as a submux
+// run inside the interceptors of its parent.
+func (m *RespondMux) Use(interceptors ...ServerInterceptor) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.is = append(m.is, interceptors...)
+}
+
+// intercept returns h wrapped with the interceptors of m.
+func (m *RespondMux) intercept(h Handler) Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return ChainServer(h, m.is...)
 }
 
 // Handler returns the handler to use for the given call, consulting
@@ -130,7 +146,11 @@ func (m *RespondMux) Match(selector string) (h Handler, pattern string) {
 	for _, e := range m.es {
 		if strings.HasPrefix(selector, e.pattern) {
 			if m, ok := e.h.(matcher); ok {
-				return m.Match(strings.TrimPrefix(selector, e.pattern))
+				h, pattern = m.Match(strings.TrimPrefix(selector, e.pattern))
+				if sub, ok := m.(*RespondMux); ok && h != nil {
+					h = sub.intercept(h)
+				}
+				return h, pattern
 			}
 			return e.h, e.pattern
 		}
@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/progrium/qtalk-go/mux"
+)
+
+// A ServerInterceptor intercepts incoming calls before they reach a Handler.
+// It sees the selector, metadata and context on the Call, and can change them
+// before passing the call on to next. It can wrap the Responder to see or
+// change the values returned, or replace the Decoder on the Call to see the
+// arguments as the handler receives them, which also covers streamed calls.
+// To short-circuit a call, it responds itself and doesn't call next.
+type ServerInterceptor func(r Responder, c *Call, next Handler)
+
+// A ClientInterceptor intercepts outgoing calls. It sees the selector, args
+// and replies, along with any metadata set on ctx, and can change them before
+// passing the call on to next. After next returns it can see or change the
+// Response and reply values. Streamed calls pass a channel as args and can be
+// continued through the Response. To short-circuit a call, it returns
+// without calling next.
+type ClientInterceptor func(ctx context.Context, selector string, args any, replies []any, next Caller) (*Response, error)
+
+// ChainServer returns a Handler that runs the interceptors around h.
+// The first interceptor is the outermost one.
+func ChainServer(h Handler, interceptors ...ServerInterceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		h = interceptedHandler{interceptor: interceptors[i], next: h}
+	}
+	return h
+}
+
+type interceptedHandler struct {
+	interceptor ServerInterceptor
+	next        Handler
+}
+
+func (h interceptedHandler) RespondRPC(r Responder, c *Call) {
+	h.interceptor(r, c, h.next)
+}
+
+// Intercept returns a Caller that runs the interceptors around calls made
+// with c. The first interceptor is the outermost one.
+func Intercept(c Caller, interceptors ...ClientInterceptor) Caller {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		c = interceptedCaller{interceptor: interceptors[i], next: c}
+	}
+	return c
+}
+
+type interceptedCaller struct {
+	interceptor ClientInterceptor
+	next        Caller
+}
+
+func (c interceptedCaller) Call(ctx context.Context, selector string, args any, replies ...any) (*Response, error) {
+	return c.interceptor(ctx, selector, args, replies, c.next)
+}
+
+// callerFunc is an adapter to use a function as a Caller.
+type callerFunc func(ctx context.Context, selector string, args any, replies ...any) (*Response, error)
+
+func (f callerFunc) Call(ctx context.Context, selector string, args any, replies ...any) (*Response, error) {
+	return f(ctx, selector, args, replies...)
+}
+
+// trackingResponder wraps a Responder to keep track of how the handler responded.
+type trackingResponder struct {
+	Responder
+	responded bool
+	continued bool
+	err       error
+}
+
+func (r *trackingResponder) Return(v ...any) error {
+	r.track(v, false)
+	return r.Responder.Return(v...)
+}
+
+func (r *trackingResponder) Continue(v ...any) (mux.Channel, error) {
+	r.track(v, true)
+	return r.Responder.Continue(v...)
+}
+
+func (r *trackingResponder) SetTrailer(key, value string) {
+	SetTrailer(r.Responder, key, value)
+}
+
+func (r *trackingResponder) Hijack() (mux.Channel, error) {
+	ch, err := Hijack(r.Responder)
+	if err == nil {
+		r.track(nil, true)
+	}
+	return ch, err
+}
+
+func (r *trackingResponder) track(v []any, continued bool) {
+	r.responded = true
+	r.continued = continued
+	if len(v) == 1 {
+		r.err, _ = v[0].(error)
+	}
+}
+
+// Recover returns a ServerInterceptor that recovers from panics in the
+// handler and returns an Internal error to the caller if the handler
+// had not responded yet.
+func Recover() ServerInterceptor {
+	return func(r Responder, c *Call, next Handler) {
+		tr := &trackingResponder{Responder: r}
+		defer func() {
+			if p := recover(); p != nil && !tr.responded {
+				r.Return(Errorf(Internal, "panic: %v", p))
+			}
+		}()
+		next.RespondRPC(tr, c)
+	}
+}
+
+// LogCalls returns a ServerInterceptor that logs each call handled
+// with its duration and any error returned. If l is nil, the standard
+// logger is used.
+func LogCalls(l *log.Logger) ServerInterceptor {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(r Responder, c *Call, next Handler) {
+		tr := &trackingResponder{Responder: r}
+		start := time.Now()
+		next.RespondRPC(tr, c)
+		if tr.err != nil {
+			l.Printf("rpc: %s %v: %v", c.Selector, time.Since(start), tr.err)
+			return
+		}
+		l.Printf("rpc: %s %v", c.Selector, time.Since(start))
+	}
+}
+
+// LogClientCalls returns a ClientInterceptor that logs each call made
+// with its duration and any error. If l is nil, the standard logger is used.
+func LogClientCalls(l *log.Logger) ClientInterceptor {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(ctx context.Context, selector string, args any, replies []any, next Caller) (*Response, error) {
+		start := time.Now()
+		resp, err := next.Call(ctx, selector, args, replies...)
+		if err != nil {
+			l.Printf("rpc: call %s %v: %v", selector, time.Since(start), err)
+			return resp, err
+		}
+		l.Printf("rpc: call %s %v", selector, time.Since(start))
+		return resp, err
+	}
+}
+
+// Timeout returns a ServerInterceptor that limits the Context of each call
+// to d. The Context of continued calls is left to time out on its own once
+// the handler returns.
+func Timeout(d time.Duration) ServerInterceptor {
+	return func(r Responder, c *Call, next Handler) {
+		ctx, cancel := context.WithTimeout(c.Context, d)
+		tr := &trackingResponder{Responder: r}
+		defer func() {
+			if !tr.continued {
+				cancel()
+			}
+		}()
+		c.Context = ctx
+		next.RespondRPC(tr, c)
+	}
+}
+
+// ClientTimeout returns a ClientInterceptor that limits each call to d.
+// For continued calls, this only limits the call up to the response.
+func ClientTimeout(d time.Duration) ClientInterceptor {
+	return func(ctx context.Context, selector string, args any, replies []any, next Caller) (*Response, error) {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return next.Call(ctx, selector, args, replies...)
+	}
+}
@@ -0,0 +1,40 @@
+package rpc
+
+import "context"
+
+// Metadata holds string values sent along with a call or its response,
+// such as auth tokens, trace IDs or client versions.
+type Metadata map[string]string
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying md merged over any metadata
+// already on ctx. Calls made with the returned context send the metadata
+// in their CallHeader.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := Metadata{}
+	for k, v := range MetadataFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFromContext returns the metadata set on ctx with WithMetadata,
+// or nil if there is none.
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+// SetTrailer sets a metadata value on r to send back to the caller in the
+// ResponseHeader, if r is a TrailerSetter, and reports whether it is.
+func SetTrailer(r Responder, key, value string) bool {
+	ts, ok := r.(TrailerSetter)
+	if ok {
+		ts.SetTrailer(key, value)
+	}
+	return ok
+}
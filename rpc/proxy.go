@@ -1,6 +1,10 @@
 package rpc
 
-import "io"
+import (
+	"io"
+
+	"github.com/progrium/qtalk-go/mux"
+)
 
 // ProxyHandler returns a handler that tries its best to proxy the
 // call to the dst Client, regardless of call style and assuming the
@@ -13,27 +17,39 @@ func ProxyHandler(dst *Client) Handler {
 			return
 		}
 
-		framer := &FrameCodec{Codec: dst.codec}
+		framer := &FrameCodec{Codec: dst.codec, MaxFrameSize: dst.MaxFrameSize}
 		enc := framer.Encoder(ch)
-		err = enc.Encode(CallHeader{
+		header := CallHeader{
 			Selector: c.Selector,
-		})
+			Metadata: c.Metadata,
+		}
+		err = header.setTimeout(c.Context)
+		if err == nil {
+			err = enc.Encode(header)
+		}
 		if err != nil {
 			ch.Close()
 			r.Return(err)
 			return
 		}
 
+		// the response comes from dst, so none is sent here
+		src, err := Hijack(r)
+		if err != nil {
+			ch.Close()
+			r.Return(Errorf(Internal, "rpc: proxy handler: %v", err))
+			return
+		}
+
 		go func() {
-			io.Copy(ch, c.Channel)
-			ch.CloseWrite()
+			if _, err := io.Copy(ch, src); !mux.ForwardReset(err, ch, src) {
+				ch.CloseWrite()
+			}
 		}()
 		go func() {
-			io.Copy(c.Channel, ch)
-			c.Channel.Close()
+			if _, err := io.Copy(src, ch); !mux.ForwardReset(err, ch, src) {
+				src.Close()
+			}
 		}()
-
-		r.(*responder).responded = true
-		r.(*responder).header.Continue = true
 	})
 }
@@ -4,7 +4,9 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestProxyHandlerUnaryRPC(t *testing.T) {
@@ -64,3 +66,68 @@ func TestProxyHandlerBytestream(t *testing.T) {
 		t.Fatal("unexpected return data:", string(b))
 	}
 }
+
+type wrappedResponder struct {
+	Responder
+}
+
+func (r wrappedResponder) Unwrap() Responder {
+	return r.Responder
+}
+
+func TestProxyHandlerWrappedResponder(t *testing.T) {
+	ctx := context.Background()
+
+	backmux := NewRespondMux()
+	backmux.Handle("echo", HandlerFunc(func(r Responder, c *Call) {
+		c.Receive(nil)
+		ch, err := r.Continue(nil)
+		fatal(t, err)
+		io.Copy(ch, ch)
+		ch.Close()
+	}))
+
+	backend, _ := newTestPair(backmux)
+	defer backend.Close()
+
+	frontmux := NewRespondMux()
+	frontmux.Use(Timeout(time.Second), func(r Responder, c *Call, next Handler) {
+		next.RespondRPC(wrappedResponder{r}, c)
+	})
+	frontmux.Handle("", ProxyHandler(backend))
+
+	client, _ := newTestPair(frontmux)
+	defer client.Close()
+
+	resp, err := client.Call(ctx, "echo", nil, nil)
+	fatal(t, err)
+	_, err = io.WriteString(resp.Channel, "Hello world")
+	fatal(t, err)
+	fatal(t, resp.Channel.CloseWrite())
+	b, err := ioutil.ReadAll(resp.Channel)
+	fatal(t, err)
+	if string(b) != "Hello world" {
+		t.Fatal("unexpected return data:", string(b))
+	}
+}
+
+func TestProxyHandlerOpaqueResponder(t *testing.T) {
+	ctx := context.Background()
+
+	backend, _ := newTestPair(NewRespondMux())
+	defer backend.Close()
+
+	frontmux := NewRespondMux()
+	frontmux.Use(func(r Responder, c *Call, next Handler) {
+		next.RespondRPC(struct{ Responder }{r}, c)
+	})
+	frontmux.Handle("", ProxyHandler(backend))
+
+	client, _ := newTestPair(frontmux)
+	defer client.Close()
+
+	_, err := client.Call(ctx, "echo", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), errNotHijacker.Error()) {
+		t.Fatal("unexpected error:", err)
+	}
+}
@@ -2,6 +2,9 @@ package rpc
 
 import (
 	"context"
+	"crypto/x509"
+	"errors"
+	"time"
 
 	"github.com/progrium/qtalk-go/codec"
 	"github.com/progrium/qtalk-go/mux"
@@ -14,8 +17,9 @@ import (
 // values for asynchronously streaming multiple values from another goroutine, however
 // the call will still block until a response is sent. If there is an error making the call
 // an error is returned, and if an error is returned by the remote handler a RemoteError
-// is returned. Multiple reply parameters can be provided in order to receive multi-valued
-// returns from the remote call.
+// is returned, or a *RemoteCodeError wrapping one if the error has a code or details.
+// Multiple reply parameters can be provided in order to receive multi-valued returns
+// from the remote call.
 //
 // A Response value is also returned for advanced operations. For example, you can check
 // if the call is continued, meaning the underlying channel will be kept open for either
@@ -27,16 +31,53 @@ type Caller interface {
 // CallHeader is the first value encoded over the channel to make a call.
 type CallHeader struct {
 	Selector string
+
+	// Timeout is how long the caller's context had left before its
+	// deadline when the call was made, if it has one. It is relative so
+	// that the handler's deadline doesn't depend on the clocks of both
+	// ends agreeing.
+	Timeout time.Duration `json:",omitempty"`
+
+	// Metadata is the metadata set on the caller's context with WithMetadata.
+	Metadata Metadata `json:",omitempty"`
+}
+
+// setTimeout sets the timeout of the header to the time left before the
+// deadline of ctx, if it has one, failing if the deadline has passed.
+func (h *CallHeader) setTimeout(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	h.Timeout = time.Until(deadline)
+	if h.Timeout <= 0 {
+		return context.DeadlineExceeded
+	}
+	return nil
 }
 
 // Call is used on the responding side of a call and is passed to the handler.
 // Call has a Caller so it can be used to make calls back to the calling side.
+//
+// The Context of a Call has a deadline taken from the Timeout sent by the
+// caller as soon as the call arrives, if there is one, and is
+// cancelled when the caller closes or resets the channel, such as when it
+// gives up on the call, or when the handler returns without calling Continue.
+// Once the caller gives up, reading from or writing to the Channel fails
+// with mux.ErrChannelReset if the session supports resets.
+//
+// Principal is who the caller authenticated as when the session was
+// established with a mux.Authenticator, and is empty otherwise.
+// PeerCertificates is the certificate chain the caller presented when
+// the session runs over TLS, so handlers can authorize by client cert.
 type Call struct {
 	CallHeader
 
-	Caller  Caller
-	Decoder codec.Decoder
-	Context context.Context
+	Caller           Caller
+	Decoder          codec.Decoder
+	Context          context.Context
+	Principal        string              `json:"-"`
+	PeerCertificates []*x509.Certificate `json:"-"`
 
 	mux.Channel
 }
@@ -56,6 +97,14 @@ func (c *Call) Receive(v interface{}) error {
 type ResponseHeader struct {
 	Error    *string
 	Continue bool // after parsing response, keep stream open for whatever protocol
+
+	// ErrorCode and ErrorDetails are set along with Error when
+	// the handler returns an error with a code or details.
+	ErrorCode    Code `json:",omitempty"`
+	ErrorDetails any  `json:",omitempty"`
+
+	// Metadata holds the trailers set by the handler with SetTrailer.
+	Metadata Metadata `json:",omitempty"`
 }
 
 // Response is used on the calling side to represent a response and allow access
@@ -95,17 +144,79 @@ type Responder interface {
 	Send(interface{}) error
 }
 
+// A TrailerSetter is a Responder that can send metadata back to the caller.
+// Handlers can use the SetTrailer function rather than asserting for it.
+type TrailerSetter interface {
+	// SetTrailer sets a metadata value to send back to the caller in the ResponseHeader.
+	// It has no effect after Return or Continue has been called.
+	SetTrailer(key, value string)
+}
+
+// A Hijacker is a Responder that lets a handler take over the channel
+// without sending a response, so the response can come from elsewhere,
+// such as a proxied call. Handlers can use the Hijack function rather
+// than asserting for it.
+type Hijacker interface {
+	// Hijack sets the call as responded and continued without sending the
+	// ResponseHeader, and returns the underlying channel. The handler must
+	// then send the response itself and close the channel.
+	Hijack() (mux.Channel, error)
+}
+
+var (
+	errResponded   = errors.New("rpc: response already sent")
+	errNotHijacker = errors.New("rpc: responder can't be hijacked")
+)
+
+// Hijack takes over the channel of r if it is a Hijacker. Responders that
+// wrap another Responder without implementing Hijacker themselves can
+// expose the one they wrap with an Unwrap() Responder method.
+func Hijack(r Responder) (mux.Channel, error) {
+	for {
+		switch v := r.(type) {
+		case Hijacker:
+			return v.Hijack()
+		case interface{ Unwrap() Responder }:
+			r = v.Unwrap()
+		default:
+			return nil, errNotHijacker
+		}
+	}
+}
+
 type responder struct {
 	responded bool
 	header    *ResponseHeader
 	ch        mux.Channel
 	c         codec.Codec
+
+	// err is the error returned to the caller or hit while sending the response.
+	err error
 }
 
 func (r *responder) Send(v interface{}) error {
 	return r.c.Encoder(r.ch).Encode(v)
 }
 
+func (r *responder) SetTrailer(key, value string) {
+	if r.responded {
+		return
+	}
+	if r.header.Metadata == nil {
+		r.header.Metadata = Metadata{}
+	}
+	r.header.Metadata[key] = value
+}
+
+func (r *responder) Hijack() (mux.Channel, error) {
+	if r.responded {
+		return nil, errResponded
+	}
+	r.responded = true
+	r.header.Continue = true
+	return r.ch, nil
+}
+
 func (r *responder) Return(v ...any) error {
 	return r.respond(v, false)
 }
@@ -129,10 +240,13 @@ func (r *responder) respond(values []any, continue_ bool) error {
 		if e != nil {
 			var errStr = e.Error()
 			r.header.Error = &errStr
+			r.header.ErrorCode, r.header.ErrorDetails = errorCode(e)
+			r.err = e
 		}
 	}
 
 	if err := r.Send(r.header); err != nil {
+		r.err = err
 		return err
 	}
 
@@ -143,6 +257,7 @@ func (r *responder) respond(values []any, continue_ bool) error {
 	}
 	for _, v := range values {
 		if err := r.Send(v); err != nil {
+			r.err = err
 			return err
 		}
 	}
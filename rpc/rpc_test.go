@@ -2,15 +2,20 @@ package rpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/progrium/qtalk-go/codec"
 	"github.com/progrium/qtalk-go/mux"
+	"github.com/progrium/qtalk-go/observe"
 )
 
 func fatal(t *testing.T, err error) {
@@ -21,18 +26,42 @@ func fatal(t *testing.T, err error) {
 }
 
 func newTestPair(handler Handler) (*Client, *Server) {
+	srv := &Server{
+		Codec:   codec.JSONCodec{},
+		Handler: handler,
+	}
+	return serveTestPair(srv), srv
+}
+
+// serveTestPair responds to calls with srv and returns a client
+// connected to it by in-memory pipes.
+func serveTestPair(srv *Server) *Client {
 	ar, bw := io.Pipe()
 	br, aw := io.Pipe()
 	sessA, _ := mux.DialIO(aw, ar)
 	sessB, _ := mux.DialIO(bw, br)
 
-	srv := &Server{
-		Codec:   codec.JSONCodec{},
-		Handler: handler,
-	}
 	go srv.Respond(sessA, nil)
 
-	return NewClient(sessB, codec.JSONCodec{}), srv
+	return NewClient(sessB, codec.JSONCodec{})
+}
+
+// syncBuffer is a strings.Builder that is safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
 }
 
 func TestServerNoCodec(t *testing.T) {
@@ -97,8 +126,9 @@ func TestRespondMux(t *testing.T) {
 			t.Fatal("expected error")
 		}
 		if err != nil {
-			rErr, ok := err.(RemoteError)
-			if !ok {
+			// not found errors have a code, so the RemoteError is wrapped
+			var rErr RemoteError
+			if !errors.As(err, &rErr) || !errors.Is(err, NotFound) {
 				t.Fatal("unexpected error:", err)
 			}
 			if rErr.Error() != "remote: not found: /baz" {
@@ -490,3 +520,414 @@ func TestRPC(t *testing.T) {
 	})
 
 }
+
+func TestServerShutdown(t *testing.T) {
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	client, srv := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+		fatal(t, c.Receive(nil))
+		close(started)
+		<-release
+		r.Return("done")
+	}))
+	defer client.Close()
+
+	callErr := make(chan error, 1)
+	var out string
+	go func() {
+		_, err := client.Call(ctx, "", nil, &out)
+		callErr <- err
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	fatal(t, <-callErr)
+	if out != "done" {
+		t.Fatal("unexpected return:", out)
+	}
+	fatal(t, <-shutdownErr)
+
+	if _, err := client.Call(ctx, "", nil, nil); err == nil {
+		t.Fatal("expected call after shutdown to fail")
+	}
+}
+
+func TestObserver(t *testing.T) {
+	m := observe.NewMetrics()
+	client := serveTestPair(&Server{
+		Codec:    codec.JSONCodec{},
+		Observer: m,
+		Handler: HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			if c.Selector == "/fail" {
+				r.Return(fmt.Errorf("failed"))
+				return
+			}
+			r.Return("ok")
+		}),
+	})
+	defer client.Close()
+	client.Observer = m
+
+	ctx := context.Background()
+	_, err := client.Call(ctx, "ok", nil, nil)
+	fatal(t, err)
+	if _, err := client.Call(ctx, "fail", nil, nil); err == nil {
+		t.Fatal("expected call to fail")
+	}
+
+	// the server finishes a call after the response is sent,
+	// so wait for it to be counted
+	metrics := func() string {
+		var b strings.Builder
+		m.WriteTo(&b)
+		return b.String()
+	}
+	for i := 0; i < 100 && !strings.Contains(metrics(), `side="server",selector="/fail"`); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	for _, line := range []string{
+		`qtalk_rpc_call_duration_seconds_count{side="client",selector="ok",status="ok"} 1`,
+		`qtalk_rpc_call_duration_seconds_count{side="client",selector="fail",status="error"} 1`,
+		`qtalk_rpc_call_duration_seconds_count{side="server",selector="/ok",status="ok"} 1`,
+		`qtalk_rpc_call_duration_seconds_count{side="server",selector="/fail",status="error"} 1`,
+	} {
+		if !strings.Contains(metrics(), line) {
+			t.Fatalf("missing metric %s in:\n%s", line, metrics())
+		}
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	t.Run("deadline", func(t *testing.T) {
+		deadline := make(chan time.Time, 1)
+		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			d, _ := c.Context.Deadline()
+			deadline <- d
+			r.Return(nil)
+		}))
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		_, err := client.Call(ctx, "", nil, nil)
+		fatal(t, err)
+
+		// the deadline is taken from the time left when the call arrives,
+		// so it is no earlier than the caller's
+		want, _ := ctx.Deadline()
+		if got := <-deadline; got.Before(want) || got.Sub(want) > time.Second {
+			t.Fatalf("unexpected deadline: %v, expected %v", got, want)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		started := make(chan struct{})
+		cancelled := make(chan error, 1)
+		reset := make(chan error, 1)
+		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			close(started)
+			select {
+			case <-c.Context.Done():
+				cancelled <- c.Context.Err()
+			case <-time.After(time.Second):
+				cancelled <- nil
+			}
+			_, err := c.Channel.Read(make([]byte, 1))
+			reset <- err
+		}))
+		defer client.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+		if _, err := client.Call(ctx, "", nil, nil); err != context.Canceled {
+			t.Fatalf("unexpected call error: %v", err)
+		}
+		if err := <-cancelled; err != context.Canceled {
+			t.Fatalf("expected handler context to be cancelled, got: %v", err)
+		}
+		// the handler can tell the call was abandoned
+		var resetErr *mux.ResetError
+		if err := <-reset; !errors.As(err, &resetErr) || resetErr.Code != uint32(Canceled) {
+			t.Fatalf("expected channel to be reset with Canceled, got: %v", err)
+		}
+	})
+
+	t.Run("channel without close notify", func(t *testing.T) {
+		a, b := net.Pipe()
+		sessA, sessB := mux.New(a), mux.New(b)
+		defer sessA.Close()
+		defer sessB.Close()
+		go sessB.Accept()
+		ch, err := sessA.Open(context.Background())
+		fatal(t, err)
+
+		// a continued call's context is cancelled once the handler closes
+		// the channel, even if the channel can't notify when it is closed
+		ctx, _, wrapped := callContext(context.Background(), time.Time{}, struct{ mux.Channel }{ch})
+		if _, ok := wrapped.(mux.Resetter); !ok {
+			t.Fatal("wrapped channel is not a Resetter")
+		}
+		fatal(t, wrapped.Close())
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("context was not cancelled when the channel was closed")
+		}
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	// trailers are set through Responders wrapped by interceptors
+	client := serveTestPair(&Server{
+		Codec: codec.JSONCodec{},
+		Handler: HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			if !SetTrailer(r, "tenant", c.Metadata["tenant"]) {
+				t.Error("responder does not support trailers")
+			}
+			SetTrailer(r, "version", "2")
+			r.Return(c.Metadata["trace"])
+		}),
+		Interceptors: []ServerInterceptor{Recover()},
+	})
+	defer client.Close()
+
+	ctx := WithMetadata(context.Background(), Metadata{"tenant": "acme", "trace": "abc"})
+	ctx = WithMetadata(ctx, Metadata{"trace": "def"})
+
+	var out string
+	resp, err := client.Call(ctx, "", nil, &out)
+	fatal(t, err)
+	if out != "def" {
+		t.Fatal("unexpected trace metadata:", out)
+	}
+	if resp.Metadata["tenant"] != "acme" || resp.Metadata["version"] != "2" {
+		t.Fatal("unexpected trailers:", resp.Metadata)
+	}
+
+	// calls without metadata leave the field out
+	resp, err = client.Call(context.Background(), "", nil, &out)
+	fatal(t, err)
+	if out != "" {
+		t.Fatal("unexpected trace metadata:", out)
+	}
+}
+
+func TestRemoteError(t *testing.T) {
+	type quota struct {
+		Limit int
+		Used  int
+	}
+	client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+		fatal(t, c.Receive(nil))
+		switch c.Selector {
+		case "/quota":
+			r.Return(&Error{
+				Code:    PermissionDenied,
+				Message: "quota exceeded",
+				Details: quota{Limit: 10, Used: 12},
+			})
+		case "/timeout":
+			r.Return(fmt.Errorf("fetching: %w", context.DeadlineExceeded))
+		default:
+			NotFoundHandler().RespondRPC(r, c)
+		}
+	}))
+	defer client.Close()
+	ctx := context.Background()
+
+	_, err := client.Call(ctx, "quota", nil, nil)
+	if !errors.Is(err, PermissionDenied) || errors.Is(err, NotFound) {
+		t.Fatalf("unexpected error code: %v", err)
+	}
+	var codeErr *RemoteCodeError
+	if !errors.As(err, &codeErr) || codeErr.Code != PermissionDenied {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rErr RemoteError
+	if !errors.As(err, &rErr) || string(rErr) != "quota exceeded" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var q quota
+	fatal(t, codeErr.DecodeDetails(&q))
+	if q.Limit != 10 || q.Used != 12 {
+		t.Fatalf("unexpected details: %+v", q)
+	}
+
+	_, err = client.Call(ctx, "timeout", nil, nil)
+	if !errors.Is(err, DeadlineExceeded) {
+		t.Fatalf("unexpected error code: %v", err)
+	}
+
+	_, err = client.Call(ctx, "missing", nil, nil)
+	if !errors.Is(err, NotFound) || err.Error() != "remote: not found: /missing" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("server", func(t *testing.T) {
+		var logs syncBuffer
+		mux := NewRespondMux()
+		mux.Handle("echo", HandlerFunc(func(r Responder, c *Call) {
+			var in string
+			fatal(t, c.Receive(&in))
+			r.Return(in)
+		}))
+		mux.Handle("panic", HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			panic("boom")
+		}))
+		mux.Handle("slow", HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			<-c.Context.Done()
+			r.Return(c.Context.Err())
+		}))
+		mux.Use(func(r Responder, c *Call, next Handler) {
+			if c.Metadata["token"] != "secret" {
+				r.Return(Errorf(Unauthenticated, "missing token"))
+				return
+			}
+			next.RespondRPC(r, c)
+		})
+
+		client := serveTestPair(&Server{
+			Codec:        codec.JSONCodec{},
+			Handler:      mux,
+			Interceptors: []ServerInterceptor{Recover(), LogCalls(log.New(&logs, "", 0)), Timeout(20 * time.Millisecond)},
+		})
+		defer client.Close()
+
+		if _, err := client.Call(ctx, "echo", "hi", nil); !errors.Is(err, Unauthenticated) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		authed := WithMetadata(ctx, Metadata{"token": "secret"})
+		var out string
+		_, err := client.Call(authed, "echo", "hi", &out)
+		fatal(t, err)
+		if out != "hi" {
+			t.Fatal("unexpected return:", out)
+		}
+
+		if _, err := client.Call(authed, "panic", nil, nil); !errors.Is(err, Internal) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := client.Call(authed, "slow", nil, nil); !errors.Is(err, DeadlineExceeded) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for i := 0; i < 100 && !strings.Contains(logs.String(), "/slow"); i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if !strings.Contains(logs.String(), "rpc: /echo") || !strings.Contains(logs.String(), "/slow") {
+			t.Fatalf("unexpected log output: %s", logs.String())
+		}
+	})
+
+	t.Run("client", func(t *testing.T) {
+		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+			var in string
+			fatal(t, c.Receive(&in))
+			r.Return(in + " " + c.Metadata["version"])
+		}))
+		defer client.Close()
+
+		client.Interceptors = []ClientInterceptor{
+			ClientTimeout(time.Second),
+			func(ctx context.Context, selector string, args any, replies []any, next Caller) (*Response, error) {
+				if selector == "blocked" {
+					return nil, Errorf(PermissionDenied, "blocked locally")
+				}
+				ctx = WithMetadata(ctx, Metadata{"version": "v1"})
+				resp, err := next.Call(ctx, selector, args, replies...)
+				if out, ok := resp.Reply.(*string); ok {
+					*out = strings.ToUpper(*out)
+				}
+				return resp, err
+			},
+		}
+
+		var out string
+		_, err := client.Call(ctx, "echo", "hi", &out)
+		fatal(t, err)
+		if out != "HI V1" {
+			t.Fatal("unexpected return:", out)
+		}
+		if _, err := client.Call(ctx, "blocked", nil, nil); !errors.Is(err, PermissionDenied) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestPrincipal(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := mux.NewWithConfig(a, mux.Config{
+		Authenticator: mux.TokenServerAuth{Verify: func(token string) (string, error) {
+			return "user:" + token, nil
+		}},
+	})
+	sessB := mux.NewWithConfig(b, mux.Config{
+		Authenticator: mux.TokenClientAuth{Token: "alice"},
+	})
+
+	srv := &Server{
+		Codec: codec.JSONCodec{},
+		Handler: HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			r.Return(c.Principal)
+		}),
+	}
+	go srv.Respond(sessA, nil)
+
+	client := NewClient(sessB, codec.JSONCodec{})
+	defer client.Close()
+
+	var principal string
+	_, err := client.Call(context.Background(), "", nil, &principal)
+	fatal(t, err)
+	if principal != "user:alice" {
+		t.Fatal("unexpected principal:", principal)
+	}
+}
+
+// BenchmarkCall makes unary calls over in-memory pipes, reporting
+// the allocations made per call on both ends.
+func BenchmarkCall(b *testing.B) {
+	client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+		var args string
+		if err := c.Receive(&args); err != nil {
+			r.Return(err)
+			return
+		}
+		r.Return(args)
+	}))
+	defer client.Close()
+
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var reply string
+		if _, err := client.Call(ctx, "echo", "hello", &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
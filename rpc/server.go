@@ -2,31 +2,141 @@ package rpc
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/progrium/qtalk-go/codec"
 	"github.com/progrium/qtalk-go/mux"
+	"github.com/progrium/qtalk-go/observe"
 )
 
+// ErrServerClosed is returned by the Server's Serve and ServeMux methods
+// after a call to Shutdown.
+var ErrServerClosed = errors.New("rpc: server closed")
+
 // Server wraps a Handler and codec to respond to RPC calls.
 type Server struct {
 	Handler Handler
 	Codec   codec.Codec
+
+	// Observer, if set, is notified when calls start and finish.
+	Observer observe.Observer
+
+	// Interceptors are run around Handler for each call, the first one outermost.
+	Interceptors []ServerInterceptor
+
+	// MaxFrameSize is the largest frame accepted from callers. It defaults
+	// to DefaultMaxFrameSize.
+	MaxFrameSize uint32
+
+	mu           sync.Mutex
+	listeners    map[mux.Listener]struct{}
+	sessions     map[mux.Session]struct{}
+	shuttingDown bool
 }
 
 // ServeMux will Accept sessions until the Listener is closed, and will Respond to accepted sessions in their own goroutine.
 func (s *Server) ServeMux(l mux.Listener) error {
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+
 	for {
 		sess, err := l.Accept()
 		if err != nil {
+			if s.isShuttingDown() {
+				return ErrServerClosed
+			}
 			return err
 		}
 		go s.Respond(sess, nil)
 	}
 }
 
+// Shutdown gracefully shuts down the server. It closes all listeners, then
+// shuts down every session being responded to, letting in-flight calls
+// finish until ctx expires. Sessions that don't support graceful shutdown
+// are closed immediately.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	var sessions []mux.Session
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	errs := make(chan error, len(sessions))
+	for _, sess := range sessions {
+		go func(sess mux.Session) {
+			if sd, ok := sess.(mux.Shutdowner); ok {
+				errs <- sd.Shutdown(ctx)
+				return
+			}
+			errs <- sess.Close()
+		}(sess)
+	}
+	var err error
+	for range sessions {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
+
+// trackListener adds or removes a listener from the set closed by Shutdown.
+// It returns false if a listener can't be added because of a Shutdown.
+func (s *Server) trackListener(l mux.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listeners == nil {
+		s.listeners = make(map[mux.Listener]struct{})
+	}
+	if add {
+		if s.shuttingDown {
+			return false
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+// trackSession adds or removes a session from the set shut down by Shutdown.
+// It returns false if a session can't be added because of a Shutdown.
+func (s *Server) trackSession(sess mux.Session, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[mux.Session]struct{})
+	}
+	if add {
+		if s.shuttingDown {
+			return false
+		}
+		s.sessions[sess] = struct{}{}
+	} else {
+		delete(s.sessions, sess)
+	}
+	return true
+}
+
 // Serve will Accept sessions until the Listener is closed, and will Respond to accepted sessions in their own goroutine.
 func (s *Server) Serve(l net.Listener) error {
 	return s.ServeMux(mux.ListenerFrom(l))
@@ -36,7 +146,8 @@ func (s *Server) Serve(l net.Listener) error {
 // If Handler was not set, an empty RespondMux is used. If the handler does not initiate a response, a nil value is
 // returned. If the handler does not call Continue, the channel will be closed. Respond will panic if Codec is nil.
 //
-// If the context is not nil, it will be added to Calls. Otherwise the Call Context will be set to a context.Background().
+// If the context is not nil, the Context of each Call will be derived from it. Otherwise it will be derived from
+// context.Background().
 func (s *Server) Respond(sess mux.Session, ctx context.Context) {
 	defer sess.Close()
 
@@ -44,10 +155,16 @@ func (s *Server) Respond(sess mux.Session, ctx context.Context) {
 		panic("rpc.Respond: nil codec")
 	}
 
+	if !s.trackSession(sess, true) {
+		return
+	}
+	defer s.trackSession(sess, false)
+
 	hn := s.Handler
 	if hn == nil {
 		hn = NewRespondMux()
 	}
+	hn = ChainServer(hn, s.Interceptors...)
 
 	for {
 		ch, err := sess.Accept()
@@ -62,27 +179,42 @@ func (s *Server) Respond(sess mux.Session, ctx context.Context) {
 }
 
 func (s *Server) respond(hn Handler, sess mux.Session, ch mux.Channel, ctx context.Context) {
-	framer := &FrameCodec{Codec: s.Codec}
+	framer := &FrameCodec{Codec: s.Codec, MaxFrameSize: s.MaxFrameSize}
 	dec := framer.Decoder(ch)
 
 	var call Call
 	err := dec.Decode(&call)
 	if err != nil {
 		log.Println("rpc.Respond:", err)
+		ch.Close()
 		return
 	}
+	var deadline time.Time
+	if call.Timeout > 0 {
+		deadline = time.Now().Add(call.Timeout)
+	}
 
 	call.Selector = cleanSelector(call.Selector)
 	call.Decoder = dec
+	call.Principal = ""
+	if a, ok := sess.(mux.Authenticated); ok {
+		call.Principal = a.Principal()
+	}
+	call.PeerCertificates = nil
+	if ts, ok := sess.(mux.TLSSession); ok {
+		call.PeerCertificates = ts.PeerCertificates()
+	}
 	call.Caller = &Client{
-		Session: sess,
-		codec:   s.Codec,
+		Session:      sess,
+		Observer:     s.Observer,
+		MaxFrameSize: s.MaxFrameSize,
+		codec:        s.Codec,
 	}
 	if ctx == nil {
-		call.Context = context.Background()
-	} else {
-		call.Context = ctx
+		ctx = context.Background()
 	}
+	var cancel context.CancelFunc
+	call.Context, cancel, ch = callContext(ctx, deadline, ch)
 	call.Channel = ch
 
 	header := &ResponseHeader{}
@@ -92,11 +224,91 @@ func (s *Server) respond(hn Handler, sess mux.Session, ch mux.Channel, ctx conte
 		header: header,
 	}
 
+	if s.Observer != nil {
+		info := observe.CallInfo{Selector: call.Selector, Server: true}
+		call.Context = s.Observer.CallStart(call.Context, info)
+		start := time.Now()
+		defer func() {
+			s.Observer.CallFinish(call.Context, info, time.Since(start), resp.err)
+		}()
+	}
+
 	hn.RespondRPC(resp, &call)
 	if !resp.responded {
 		resp.Return()
 	}
 	if !resp.header.Continue {
 		ch.Close()
+		cancel()
+	}
+}
+
+// callContext derives the context of a call from ctx. It has the given
+// deadline unless it is zero, and is cancelled when ch is closed. If ch can't
+// report being closed, it returns ch wrapped to cancel the context when it is
+// closed by the handler, so a continued call doesn't leak its context.
+func callContext(ctx context.Context, deadline time.Time, ch mux.Channel) (context.Context, context.CancelFunc, mux.Channel) {
+	var cancel context.CancelFunc
+	if !deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	cn, ok := ch.(mux.CloseNotifier)
+	if !ok {
+		return ctx, cancel, &cancelChannel{Channel: ch, cancel: cancel}
+	}
+	go func() {
+		select {
+		case <-cn.CloseNotify():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel, ch
+}
+
+var errNoDeadline = errors.New("rpc: channel does not support deadlines")
+
+// cancelChannel cancels the context of a call when its channel is closed
+// or reset, for channels that can't notify when they are closed.
+type cancelChannel struct {
+	mux.Channel
+	cancel context.CancelFunc
+}
+
+func (c *cancelChannel) Close() error {
+	c.cancel()
+	return c.Channel.Close()
+}
+
+// Reset resets the channel if it supports resets, otherwise it closes it.
+func (c *cancelChannel) Reset(code uint32) error {
+	r, ok := c.Channel.(mux.Resetter)
+	if !ok {
+		return c.Close()
+	}
+	c.cancel()
+	return r.Reset(code)
+}
+
+func (c *cancelChannel) SetDeadline(t time.Time) error {
+	if d, ok := c.Channel.(mux.Deadliner); ok {
+		return d.SetDeadline(t)
+	}
+	return errNoDeadline
+}
+
+func (c *cancelChannel) SetReadDeadline(t time.Time) error {
+	if d, ok := c.Channel.(mux.Deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return errNoDeadline
+}
+
+func (c *cancelChannel) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.Channel.(mux.Deadliner); ok {
+		return d.SetWriteDeadline(t)
 	}
+	return errNoDeadline
 }
@@ -19,6 +19,12 @@ func init() {
 		"tcp":  mux.DialTCP,
 		"unix": mux.DialUnix,
 		"ws":   mux.DialWS,
+		"tls": func(addr string) (mux.Session, error) {
+			return mux.DialTLS(addr, nil)
+		},
+		"wss": func(addr string) (mux.Session, error) {
+			return mux.DialWSS(addr, nil)
+		},
 		"stdio": func(_ string) (mux.Session, error) {
 			return mux.DialStdio()
 		},
@@ -26,8 +32,10 @@ func init() {
 }
 
 // Dial connects to a remote address using a registered transport and returns a Peer.
-// Available transports are "tcp", "unix", "ws", and "stdio". In the case of "stdio",
-// the addr can be left an empty string.
+// Available transports are "tcp", "unix", "ws", "tls", "wss", and "stdio". The "tls"
+// and "wss" transports verify the server with the system roots; use mux.DialTLS or
+// mux.DialWSS directly for other TLS settings. In the case of "stdio", the addr can
+// be left an empty string.
 func Dial(transport, addr string, codec codec.Codec) (*Peer, error) {
 	d, ok := Dialers[transport]
 	if !ok {
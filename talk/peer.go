@@ -1,6 +1,8 @@
 package talk
 
 import (
+	"context"
+
 	"github.com/progrium/qtalk-go/codec"
 	"github.com/progrium/qtalk-go/mux"
 	"github.com/progrium/qtalk-go/rpc"
@@ -29,6 +31,16 @@ func (p *Peer) Close() error {
 	return p.Client.Close()
 }
 
+// Shutdown gracefully shuts down the underlying session, letting in-flight
+// calls in both directions finish until ctx expires. If the session does not
+// support graceful shutdown it is closed immediately.
+func (p *Peer) Shutdown(ctx context.Context) error {
+	if sd, ok := p.Session.(mux.Shutdowner); ok {
+		return sd.Shutdown(ctx)
+	}
+	return p.Close()
+}
+
 // Respond lets the Peer respond to incoming channels like
 // a server, using any registered handlers.
 func (p *Peer) Respond() {
@@ -49,3 +49,31 @@ func TestPeerBidirectional(t *testing.T) {
 		t.Fatal("unexpected return:", retA)
 	}
 }
+
+func TestPeerShutdown(t *testing.T) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	sessA, _ := mux.DialIO(aw, ar)
+	sessB, _ := mux.DialIO(bw, br)
+
+	peerA := NewPeer(sessA, codec.JSONCodec{})
+	peerB := NewPeer(sessB, codec.JSONCodec{})
+	defer peerB.Close()
+
+	peerB.Handle("hello", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		r.Return("B")
+	}))
+	go peerB.Respond()
+
+	var ret string
+	if _, err := peerA.Call(context.Background(), "hello", nil, &ret); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := peerA.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessA.Wait(); err == nil {
+		t.Fatal("expected session to be closed")
+	}
+}
@@ -0,0 +1,245 @@
+package talk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/progrium/qtalk-go/codec"
+	"github.com/progrium/qtalk-go/mux"
+)
+
+// ErrDisconnected is returned by Open on a ReconnectingSession with
+// FailFast set while it is disconnected.
+var ErrDisconnected = errors.New("talk: session disconnected")
+
+// ReconnectConfig holds optional settings for a ReconnectingSession.
+type ReconnectConfig struct {
+	// MinBackoff is how long to wait before redialing after the first
+	// failed dial. The wait doubles after each failure, with jitter, up to
+	// MaxBackoff. They default to 100 milliseconds and 30 seconds.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// FailFast makes Open return ErrDisconnected right away while there is
+	// no connection. Otherwise Open blocks until a connection is made or
+	// its context is done.
+	FailFast bool
+
+	// OnConnect, if set, is called with the new session each time a
+	// connection is made, before the session is used by Open and Accept.
+	// It can be used to register session handlers or restore remote state.
+	OnConnect func(sess mux.Session)
+
+	// OnDisconnect, if set, is called with the error returned by Wait
+	// each time a connection is lost.
+	OnDisconnect func(err error)
+}
+
+// ReconnectingSession is a mux.Session that dials with a Dialer and redials
+// whenever the connection drops, so an rpc.Client or Peer using it outlives
+// any one connection. Channels open when a connection drops fail as usual.
+type ReconnectingSession struct {
+	dial Dialer
+	addr string
+	cfg  ReconnectConfig
+
+	mu      sync.Mutex
+	sess    mux.Session   // nil while disconnected
+	changed chan struct{} // closed when sess changes
+	closed  bool
+	done    chan struct{}
+}
+
+// NewReconnectingSession returns a session that connects to addr with
+// dial in the background and reconnects until it is closed.
+func NewReconnectingSession(dial Dialer, addr string, cfg ReconnectConfig) *ReconnectingSession {
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	r := &ReconnectingSession{
+		dial:    dial,
+		addr:    addr,
+		cfg:     cfg,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// DialReconnecting is like Dial but returns a Peer over a ReconnectingSession
+// that redials using the registered transport whenever the connection drops.
+func DialReconnecting(transport, addr string, codec codec.Codec, cfg ReconnectConfig) (*Peer, error) {
+	d, ok := Dialers[transport]
+	if !ok {
+		return nil, fmt.Errorf("transport '%s' not in available in Dialers", transport)
+	}
+	return NewPeer(NewReconnectingSession(d, addr, cfg), codec), nil
+}
+
+// run dials and waits on sessions until the ReconnectingSession is closed.
+func (r *ReconnectingSession) run() {
+	backoff := r.cfg.MinBackoff
+	for {
+		sess, err := r.dial(r.addr)
+		if err != nil {
+			select {
+			case <-time.After(jitter(backoff)):
+			case <-r.done:
+				return
+			}
+			backoff *= 2
+			if backoff > r.cfg.MaxBackoff {
+				backoff = r.cfg.MaxBackoff
+			}
+			continue
+		}
+		backoff = r.cfg.MinBackoff
+
+		if r.cfg.OnConnect != nil {
+			r.cfg.OnConnect(sess)
+		}
+		if !r.setSession(sess) {
+			sess.Close()
+			return
+		}
+		err = sess.Wait()
+		if !r.setSession(nil) {
+			return
+		}
+		if r.cfg.OnDisconnect != nil {
+			r.cfg.OnDisconnect(err)
+		}
+	}
+}
+
+// jitter returns a random duration between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// setSession makes sess the current session, waking up anything waiting
+// for it to change. It returns false if the session has been closed.
+func (r *ReconnectingSession) setSession(sess mux.Session) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	r.sess = sess
+	close(r.changed)
+	r.changed = make(chan struct{})
+	return true
+}
+
+// session returns the current session once there is one other than prev.
+func (r *ReconnectingSession) session(ctx context.Context, prev mux.Session, failFast bool) (mux.Session, error) {
+	for {
+		r.mu.Lock()
+		sess, changed, closed := r.sess, r.changed, r.closed
+		r.mu.Unlock()
+		if closed {
+			return nil, net.ErrClosed
+		}
+		if sess != nil && sess != prev {
+			return sess, nil
+		}
+		if failFast {
+			return nil, ErrDisconnected
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.done:
+		}
+	}
+}
+
+// Current returns the session of the current connection,
+// or nil while disconnected.
+func (r *ReconnectingSession) Current() mux.Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sess
+}
+
+// Open establishes a new channel over the current connection. While
+// disconnected it waits for a connection unless FailFast is set.
+func (r *ReconnectingSession) Open(ctx context.Context) (mux.Channel, error) {
+	sess, err := r.session(ctx, nil, r.cfg.FailFast)
+	if err != nil {
+		return nil, err
+	}
+	return sess.Open(ctx)
+}
+
+// Accept waits for and returns the next incoming channel from
+// any connection. It returns io.EOF once the session is closed.
+func (r *ReconnectingSession) Accept() (mux.Channel, error) {
+	var prev mux.Session
+	for {
+		sess, err := r.session(context.Background(), prev, false)
+		if err != nil {
+			return nil, io.EOF
+		}
+		ch, err := sess.Accept()
+		if err == nil {
+			return ch, nil
+		}
+		prev = sess
+	}
+}
+
+// Close stops reconnecting and closes the current connection.
+func (r *ReconnectingSession) Close() error {
+	sess, ok := r.stop()
+	if !ok || sess == nil {
+		return nil
+	}
+	return sess.Close()
+}
+
+// Shutdown stops reconnecting and gracefully shuts down the current
+// connection if it supports graceful shutdown.
+func (r *ReconnectingSession) Shutdown(ctx context.Context) error {
+	sess, ok := r.stop()
+	if !ok || sess == nil {
+		return nil
+	}
+	if sd, ok := sess.(mux.Shutdowner); ok {
+		return sd.Shutdown(ctx)
+	}
+	return sess.Close()
+}
+
+// stop marks the session closed and returns the current connection's
+// session. It returns false if the session was already closed.
+func (r *ReconnectingSession) stop() (mux.Session, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, false
+	}
+	r.closed = true
+	close(r.done)
+	return r.sess, true
+}
+
+// Wait blocks until the session is closed and returns io.EOF.
+func (r *ReconnectingSession) Wait() error {
+	<-r.done
+	return io.EOF
+}
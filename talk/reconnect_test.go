@@ -0,0 +1,112 @@
+package talk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/progrium/qtalk-go/codec"
+	"github.com/progrium/qtalk-go/mux"
+	"github.com/progrium/qtalk-go/rpc"
+)
+
+// pipeDialer returns a Dialer that connects over in-memory pipes to
+// sessions served by srv, sending each server session on sessions.
+// Dials fail while refuse is set.
+func pipeDialer(srv *rpc.Server, sessions chan<- mux.Session, refuse *int32) Dialer {
+	return func(addr string) (mux.Session, error) {
+		if atomic.LoadInt32(refuse) != 0 {
+			return nil, errors.New("connection refused")
+		}
+		a, b := net.Pipe()
+		sess := mux.New(b)
+		go srv.Respond(sess, nil)
+		sessions <- sess
+		return mux.New(a), nil
+	}
+}
+
+func TestReconnectingSession(t *testing.T) {
+	srv := &rpc.Server{
+		Codec: codec.JSONCodec{},
+		Handler: rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+			r.Return("pong")
+		}),
+	}
+	sessions := make(chan mux.Session, 2)
+	var refuse int32
+	connects := make(chan struct{}, 2)
+	disconnects := make(chan error, 2)
+	var connected int32
+
+	rs := NewReconnectingSession(pipeDialer(srv, sessions, &refuse), "", ReconnectConfig{
+		MinBackoff: time.Millisecond,
+		OnConnect: func(sess mux.Session) {
+			// the session isn't used until the hook returns
+			time.Sleep(10 * time.Millisecond)
+			atomic.StoreInt32(&connected, 1)
+			connects <- struct{}{}
+		},
+		OnDisconnect: func(err error) {
+			disconnects <- err
+		},
+	})
+	peer := NewPeer(rs, codec.JSONCodec{})
+	defer peer.Close()
+
+	call := func() {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var out string
+		if _, err := peer.Call(ctx, "ping", nil, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out != "pong" {
+			t.Fatal("unexpected reply:", out)
+		}
+		if atomic.SwapInt32(&connected, 0) == 0 {
+			t.Fatal("session used before OnConnect returned")
+		}
+	}
+
+	call()
+	<-connects
+
+	// drop the connection while refusing to redial
+	atomic.StoreInt32(&refuse, 1)
+	(<-sessions).Close()
+	<-disconnects
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rs.Open(ctx); err != context.DeadlineExceeded {
+		t.Fatal("unexpected open error while disconnected:", err)
+	}
+
+	atomic.StoreInt32(&refuse, 0)
+	call()
+	<-connects
+
+	if err := peer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Open(context.Background()); err != net.ErrClosed {
+		t.Fatal("unexpected open error after close:", err)
+	}
+}
+
+func TestReconnectingSessionFailFast(t *testing.T) {
+	refuse := int32(1)
+	rs := NewReconnectingSession(pipeDialer(nil, nil, &refuse), "", ReconnectConfig{
+		FailFast: true,
+	})
+	defer rs.Close()
+
+	if _, err := rs.Open(context.Background()); err != ErrDisconnected {
+		t.Fatal("unexpected open error:", err)
+	}
+}
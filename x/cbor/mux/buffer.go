@@ -16,6 +16,9 @@ type buffer struct {
 	tail *element // the buffer that will be read last
 
 	closed bool
+
+	// size is the number of bytes written but not yet read
+	size int
 }
 
 // An element represents a single link in a linked list.
@@ -42,6 +45,7 @@ func (b *buffer) write(buf []byte) {
 	e := &element{buf: buf}
 	b.tail.next = e
 	b.tail = e
+	b.size += len(buf)
 	b.Cond.Signal()
 	b.Cond.L.Unlock()
 }
@@ -55,6 +59,13 @@ func (b *buffer) eof() {
 	b.Cond.L.Unlock()
 }
 
+// len returns the number of bytes available to Read.
+func (b *buffer) len() int {
+	b.Cond.L.Lock()
+	defer b.Cond.L.Unlock()
+	return b.size
+}
+
 // Read reads data from the internal buffer in buf.  Reads will block
 // if no data is available, or until the buffer is closed.
 func (b *buffer) Read(buf []byte) (n int, err error) {
@@ -67,6 +78,7 @@ func (b *buffer) Read(buf []byte) (n int, err error) {
 			r := copy(buf, b.head.buf)
 			buf, b.head.buf = buf[r:], b.head.buf[r:]
 			n += r
+			b.size -= r
 			continue
 		}
 		// if there is a next buffer, make it the head
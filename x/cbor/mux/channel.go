@@ -4,11 +4,17 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 )
 
 // channel is an implementation of the Channel interface that works
 // with the session class.
 type channel struct {
+	// bytesSent and bytesReceived count data bytes. They are accessed
+	// atomically and kept first for 64-bit alignment.
+	bytesSent     uint64
+	bytesReceived uint64
+
 	localId, remoteId uint32
 	session           *session
 
@@ -57,11 +63,14 @@ func (ch *channel) Write(data []byte) (n int, err error) {
 		return 0, io.EOF
 	}
 
-	err = ch.session.enc.Encode(Frame{
+	err = ch.session.encode(Frame{
 		Type:      channelData,
 		ChannelID: ch.remoteId,
 		Data:      data,
 	})
+	if err == nil {
+		atomic.AddUint64(&ch.bytesSent, uint64(len(data)))
+	}
 
 	return n, err
 }
@@ -85,7 +94,7 @@ func (ch *channel) send(f Frame) error {
 		ch.sentClose = true
 	}
 
-	return ch.session.enc.Encode(f)
+	return ch.session.encode(f)
 }
 
 func (c *channel) close() {
@@ -101,6 +110,7 @@ func (c *channel) close() {
 func (ch *channel) handle(f Frame) error {
 	switch f.Type {
 	case channelData:
+		atomic.AddUint64(&ch.bytesReceived, uint64(len(f.Data)))
 		ch.pending.write(f.Data)
 		return nil
 
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fxamacker/cbor/v2"
@@ -25,8 +26,14 @@ type session struct {
 	chans       map[uint32]*channel
 	chanCounter uint32
 
-	enc *cbor.Encoder
-	dec *cbor.Decoder
+	// encMu serializes frame writes so they can be counted.
+	encMu sync.Mutex
+	w     *countWriter
+	enc   *cbor.Encoder
+	dec   *cbor.Decoder
+	nRead int
+
+	stats *sessionStats
 
 	inbox chan mux.Channel
 
@@ -40,10 +47,13 @@ func New(t io.ReadWriteCloser) mux.Session {
 	if t == nil {
 		return nil
 	}
+	w := &countWriter{w: t}
 	s := &session{
 		t:       t,
-		enc:     cbor.NewEncoder(t),
+		w:       w,
+		enc:     cbor.NewEncoder(w),
 		dec:     cbor.NewDecoder(t),
+		stats:   new(sessionStats),
 		inbox:   make(chan mux.Channel),
 		chans:   make(map[uint32]*channel),
 		errCond: sync.NewCond(new(sync.Mutex)),
@@ -83,7 +93,7 @@ func (s *session) Accept() (mux.Channel, error) {
 // Open establishes a new channel with the other end.
 func (s *session) Open(ctx context.Context) (mux.Channel, error) {
 	ch := s.newChannel()
-	if err := s.enc.Encode(Frame{
+	if err := s.encode(Frame{
 		Type:     channelOpen,
 		SenderID: ch.localId,
 	}); err != nil {
@@ -106,14 +116,28 @@ func (s *session) Open(ctx context.Context) (mux.Channel, error) {
 
 	switch f.Type {
 	case channelOpenConfirm:
+		atomic.AddUint64(&s.stats.opened, 1)
 		return ch, nil
 	case channelOpenFailure:
+		atomic.AddUint64(&s.stats.openFailed, 1)
 		return nil, fmt.Errorf("cmux: channel open failed on remote side")
 	default:
 		return nil, fmt.Errorf("cmux: unexpected packet in response to channel open: %v", f)
 	}
 }
 
+// encode writes a frame to the transport, counting it in the session stats.
+func (s *session) encode(f Frame) error {
+	s.encMu.Lock()
+	defer s.encMu.Unlock()
+	n := s.w.n
+	if err := s.enc.Encode(f); err != nil {
+		return err
+	}
+	s.stats.frameSent(f, s.w.n-n)
+	return nil
+}
+
 func (s *session) newChannel() *channel {
 	ch := &channel{
 		pending: newBuffer(),
@@ -161,6 +185,9 @@ func (s *session) onePacket() (err error) {
 	if err != nil {
 		return err
 	}
+	n := s.dec.NumBytesRead()
+	s.stats.frameReceived(f, n-s.nRead)
+	s.nRead = n
 
 	if f.Type == channelOpen {
 		c := s.newChannel()
@@ -169,13 +196,15 @@ func (s *session) onePacket() (err error) {
 		defer t.Stop()
 		select {
 		case s.inbox <- c:
-			return s.enc.Encode(Frame{
+			atomic.AddUint64(&s.stats.accepted, 1)
+			return s.encode(Frame{
 				Type:      channelOpenConfirm,
 				ChannelID: c.remoteId,
 				SenderID:  c.localId,
 			})
 		case <-t.C:
-			return s.enc.Encode(Frame{
+			atomic.AddUint64(&s.stats.rejected, 1)
+			return s.encode(Frame{
 				Type:      channelOpenFailure,
 				ChannelID: f.SenderID,
 			})
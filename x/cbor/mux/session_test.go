@@ -170,3 +170,41 @@ func TestSessionWait(t *testing.T) {
 		t.Fatalf("expected a network error, but got: %v", err)
 	}
 }
+
+func TestSessionStats(t *testing.T) {
+	a, b := net.Pipe()
+	sessA := New(a)
+	sessB := New(b)
+	defer sessA.Close()
+	defer sessB.Close()
+
+	accepted := make(chan mux.Channel, 1)
+	go func() {
+		ch, err := sessB.Accept()
+		fatal(err, t)
+		accepted <- ch
+	}()
+	chA, err := sessA.Open(context.Background())
+	fatal(err, t)
+	chB := <-accepted
+
+	_, err = chA.Write([]byte("hello"))
+	fatal(err, t)
+	for chB.(mux.ChannelStatsReporter).Stats().Pending != 5 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := sessA.(mux.StatsReporter).Stats()
+	if stats.OpenChannels != 1 || stats.Opened != 1 {
+		t.Fatalf("unexpected channel counts: %+v", stats)
+	}
+	if got := stats.FramesSent["Data"]; got.Frames != 1 || got.Bytes == 0 {
+		t.Fatalf("unexpected data frame stats: %+v", got)
+	}
+	if got := chA.(mux.ChannelStatsReporter).Stats(); got.BytesSent != 5 {
+		t.Fatalf("unexpected sender stats: %+v", got)
+	}
+	if got := sessB.(mux.StatsReporter).Stats(); got.Accepted != 1 || got.FramesReceived["Data"] != stats.FramesSent["Data"] {
+		t.Fatalf("unexpected accepting session stats: %+v", got)
+	}
+}
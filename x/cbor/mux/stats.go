@@ -0,0 +1,109 @@
+package mux
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/progrium/qtalk-go/mux"
+)
+
+// frameNames maps frame types to the names used in mux.SessionStats.
+var frameNames = map[byte]string{
+	channelOpen:        "Open",
+	channelOpenConfirm: "OpenConfirm",
+	channelOpenFailure: "OpenFailure",
+	channelData:        "Data",
+	channelEOF:         "EOF",
+	channelClose:       "Close",
+}
+
+// sessionStats collects the statistics of a session.
+type sessionStats struct {
+	opened     uint64
+	accepted   uint64
+	openFailed uint64
+	rejected   uint64
+
+	// mu protects sent and received.
+	mu       sync.Mutex
+	sent     map[string]mux.FrameStats
+	received map[string]mux.FrameStats
+}
+
+func (st *sessionStats) frameSent(f Frame, n int) {
+	st.mu.Lock()
+	st.sent = countFrame(st.sent, f, n)
+	st.mu.Unlock()
+}
+
+func (st *sessionStats) frameReceived(f Frame, n int) {
+	st.mu.Lock()
+	st.received = countFrame(st.received, f, n)
+	st.mu.Unlock()
+}
+
+func countFrame(m map[string]mux.FrameStats, f Frame, n int) map[string]mux.FrameStats {
+	if m == nil {
+		m = make(map[string]mux.FrameStats)
+	}
+	fs := m[frameNames[f.Type]]
+	fs.Frames++
+	fs.Bytes += uint64(n)
+	m[frameNames[f.Type]] = fs
+	return m
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
+// Stats returns a snapshot of the session statistics. There is no
+// flow control, so windows and blocked time are not reported.
+func (s *session) Stats() mux.SessionStats {
+	stats := mux.SessionStats{
+		Opened:         atomic.LoadUint64(&s.stats.opened),
+		Accepted:       atomic.LoadUint64(&s.stats.accepted),
+		OpenFailed:     atomic.LoadUint64(&s.stats.openFailed),
+		Rejected:       atomic.LoadUint64(&s.stats.rejected),
+		FramesSent:     make(map[string]mux.FrameStats),
+		FramesReceived: make(map[string]mux.FrameStats),
+	}
+
+	s.stats.mu.Lock()
+	for k, v := range s.stats.sent {
+		stats.FramesSent[k] = v
+		stats.BytesSent += v.Bytes
+	}
+	for k, v := range s.stats.received {
+		stats.FramesReceived[k] = v
+		stats.BytesReceived += v.Bytes
+	}
+	s.stats.mu.Unlock()
+
+	s.chanMu.Lock()
+	for _, ch := range s.chans {
+		stats.Channels = append(stats.Channels, ch.Stats())
+	}
+	s.chanMu.Unlock()
+	stats.OpenChannels = len(stats.Channels)
+	return stats
+}
+
+// Stats returns a snapshot of the channel statistics.
+func (ch *channel) Stats() mux.ChannelStats {
+	return mux.ChannelStats{
+		ID:            ch.localId,
+		BytesSent:     atomic.LoadUint64(&ch.bytesSent),
+		BytesReceived: atomic.LoadUint64(&ch.bytesReceived),
+		Pending:       ch.pending.len(),
+	}
+}
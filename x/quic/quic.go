@@ -3,16 +3,39 @@ package quic
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/progrium/qtalk-go/mux"
 	"github.com/progrium/qtalk-go/talk"
 	"github.com/quic-go/quic-go"
 )
 
+// closeCode is the error code Close cancels reading with, so the other
+// end stops sending.
+const closeCode = 42
+
+// errReservedCode is returned by Reset for closeCode, which the other end
+// would take for a Close.
+var errReservedCode = errors.New("quic: reset code 42 is reserved for Close")
+
+// The directions of a channel that have finished. A channel is forgotten
+// by its session once both have.
+const (
+	readFinished = 1 << iota
+	writeFinished
+)
+
 func New(conn quic.Connection) mux.Session {
-	return &session{conn}
+	return &session{
+		conn:  conn,
+		chans: make(map[*channel]struct{}),
+	}
 }
 
 var defaultTLSConfig = tls.Config{
@@ -32,7 +55,17 @@ func init() {
 }
 
 type session struct {
+	// counters are accessed atomically and kept first for 64-bit alignment
+	opened        uint64
+	accepted      uint64
+	openFailed    uint64
+	bytesSent     uint64
+	bytesReceived uint64
+
 	conn quic.Connection
+
+	mu    sync.Mutex
+	chans map[*channel]struct{}
 }
 
 func (s *session) Close() error {
@@ -52,7 +85,8 @@ func (s *session) Accept() (mux.Channel, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &channel{stream}, nil
+	atomic.AddUint64(&s.accepted, 1)
+	return s.newChannel(stream), nil
 }
 
 func (s *session) Open(ctx context.Context) (mux.Channel, error) {
@@ -62,13 +96,50 @@ func (s *session) Open(ctx context.Context) (mux.Channel, error) {
 	// acknowledgement from the remote side lead to deadlocks in the tests.
 	stream, err := s.conn.OpenStreamSync(ctx)
 	if err != nil {
+		atomic.AddUint64(&s.openFailed, 1)
 		return nil, err
 	}
 	_, err = stream.Write([]byte("!"))
 	if err != nil {
+		atomic.AddUint64(&s.openFailed, 1)
 		return nil, err
 	}
-	return &channel{stream}, nil
+	atomic.AddUint64(&s.opened, 1)
+	return s.newChannel(stream), nil
+}
+
+func (s *session) newChannel(stream quic.Stream) *channel {
+	ch := &channel{stream: stream, session: s}
+	s.mu.Lock()
+	s.chans[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch
+}
+
+// Stats reports what QUIC exposes of the mux.SessionStats. Framing and
+// flow control are handled by QUIC, so frame counts and windows are not
+// reported, and byte counts only include channel data.
+func (s *session) Stats() mux.SessionStats {
+	stats := mux.SessionStats{
+		Opened:        atomic.LoadUint64(&s.opened),
+		Accepted:      atomic.LoadUint64(&s.accepted),
+		OpenFailed:    atomic.LoadUint64(&s.openFailed),
+		BytesSent:     atomic.LoadUint64(&s.bytesSent),
+		BytesReceived: atomic.LoadUint64(&s.bytesReceived),
+	}
+	s.mu.Lock()
+	for ch := range s.chans {
+		stats.Channels = append(stats.Channels, ch.Stats())
+	}
+	s.mu.Unlock()
+	stats.OpenChannels = len(stats.Channels)
+	return stats
+}
+
+// PeerCertificates returns the certificate chain presented by the other
+// end during the QUIC handshake.
+func (s *session) PeerCertificates() []*x509.Certificate {
+	return s.conn.ConnectionState().TLS.PeerCertificates
 }
 
 func (s *session) Wait() error {
@@ -77,7 +148,32 @@ func (s *session) Wait() error {
 }
 
 type channel struct {
-	stream quic.Stream
+	// counters are accessed atomically and kept first for 64-bit alignment
+	bytesSent     uint64
+	bytesReceived uint64
+
+	stream  quic.Stream
+	session *session
+
+	finished int // guarded by session.mu
+}
+
+// finish marks the directions in dir as finished, and removes the channel
+// from its session once both are.
+func (c *channel) finish(dir int) {
+	c.session.mu.Lock()
+	c.finished |= dir
+	if c.finished == readFinished|writeFinished {
+		delete(c.session.chans, c)
+	}
+	c.session.mu.Unlock()
+}
+
+// streamDone reports whether err ends a direction of the stream, rather
+// than being a deadline that can be retried.
+func streamDone(err error) bool {
+	var streamErr *quic.StreamError
+	return err == io.EOF || errors.As(err, &streamErr)
 }
 
 func (c *channel) ID() uint32 {
@@ -85,19 +181,79 @@ func (c *channel) ID() uint32 {
 }
 
 func (c *channel) Read(p []byte) (int, error) {
-	return c.stream.Read(p)
+	n, err := c.stream.Read(p)
+	atomic.AddUint64(&c.bytesReceived, uint64(n))
+	atomic.AddUint64(&c.session.bytesReceived, uint64(n))
+	if streamDone(err) {
+		c.finish(readFinished)
+	}
+	return n, resetError(err)
 }
 
 func (c *channel) Write(p []byte) (int, error) {
-	return c.stream.Write(p)
+	n, err := c.stream.Write(p)
+	atomic.AddUint64(&c.bytesSent, uint64(n))
+	atomic.AddUint64(&c.session.bytesSent, uint64(n))
+	if streamDone(err) {
+		c.finish(writeFinished)
+	}
+	return n, resetError(err)
+}
+
+func (c *channel) Stats() mux.ChannelStats {
+	return mux.ChannelStats{
+		ID:            c.ID(),
+		BytesSent:     atomic.LoadUint64(&c.bytesSent),
+		BytesReceived: atomic.LoadUint64(&c.bytesReceived),
+	}
+}
+
+func (c *channel) SetDeadline(t time.Time) error {
+	return c.stream.SetDeadline(t)
+}
+
+func (c *channel) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+func (c *channel) SetWriteDeadline(t time.Time) error {
+	return c.stream.SetWriteDeadline(t)
 }
 
 func (c *channel) Close() error {
-	c.stream.CancelRead(42)
+	c.finish(readFinished | writeFinished)
+	c.stream.CancelRead(closeCode)
 	return c.CloseWrite()
 }
 
+// Reset aborts the stream in both directions with code, which QUIC sends
+// as STOP_SENDING and RESET_STREAM frames. Code 42 is used by Close, and
+// is rejected.
+func (c *channel) Reset(code uint32) error {
+	if code == closeCode {
+		return errReservedCode
+	}
+	c.finish(readFinished | writeFinished)
+	c.stream.CancelRead(quic.StreamErrorCode(code))
+	c.stream.CancelWrite(quic.StreamErrorCode(code))
+	return nil
+}
+
+// resetError returns a mux.ResetError for err if it is from a stream
+// that was reset by either end, other than by Close.
+func resetError(err error) error {
+	var streamErr *quic.StreamError
+	if !errors.As(err, &streamErr) || streamErr.ErrorCode == closeCode {
+		return err
+	}
+	return &mux.ResetError{
+		Code:   uint32(streamErr.ErrorCode),
+		Remote: streamErr.Remote,
+	}
+}
+
 func (c *channel) CloseWrite() error {
 	// TODO this may need a lock to avoid concurrent call with Write
+	c.finish(writeFinished)
 	return c.stream.Close()
 }
@@ -8,6 +8,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"io/ioutil"
 	"math/big"
 	"testing"
@@ -246,3 +247,90 @@ func TestOpenTimeout(t *testing.T) {
 	close(testComplete)
 	<-sessionClosed
 }
+
+func TestChannelReset(t *testing.T) {
+	l, err := quic.ListenAddr("127.0.0.1:0", generateTLSConfig(), nil)
+	fatal(err, t)
+	defer l.Close()
+
+	accepted := make(chan mux.Channel, 1)
+	go func() {
+		conn, err := l.Accept(context.Background())
+		fatal(err, t)
+		ch, err := New(conn).Accept()
+		fatal(err, t)
+		accepted <- ch
+	}()
+
+	cfg := defaultTLSConfig.Clone()
+	cfg.InsecureSkipVerify = true
+	conn, err := quic.DialAddr(l.Addr().String(), cfg, nil)
+	fatal(err, t)
+	sess := New(conn)
+	defer sess.Close()
+
+	chA, err := sess.Open(context.Background())
+	fatal(err, t)
+	chB := <-accepted
+	if err := chA.(mux.Resetter).Reset(closeCode); err != errReservedCode {
+		t.Fatalf("expected errReservedCode, but got: %v", err)
+	}
+	fatal(chA.(mux.Resetter).Reset(7), t)
+
+	_, err = ioutil.ReadAll(chB)
+	var reset *mux.ResetError
+	if !errors.As(err, &reset) || reset.Code != 7 || !reset.Remote {
+		t.Fatalf("expected remote reset with code 7, but got: %v", err)
+	}
+	_, err = chA.Write([]byte("x"))
+	if !errors.Is(err, mux.ErrChannelReset) {
+		t.Fatalf("expected ErrChannelReset, but got: %v", err)
+	}
+}
+
+func TestChannelFinished(t *testing.T) {
+	l, err := quic.ListenAddr("127.0.0.1:0", generateTLSConfig(), nil)
+	fatal(err, t)
+	defer l.Close()
+
+	accepted := make(chan mux.Channel, 1)
+	sessions := make(chan mux.Session, 1)
+	go func() {
+		conn, err := l.Accept(context.Background())
+		fatal(err, t)
+		sess := New(conn)
+		sessions <- sess
+		ch, err := sess.Accept()
+		fatal(err, t)
+		accepted <- ch
+	}()
+
+	cfg := defaultTLSConfig.Clone()
+	cfg.InsecureSkipVerify = true
+	conn, err := quic.DialAddr(l.Addr().String(), cfg, nil)
+	fatal(err, t)
+	sessA := New(conn)
+	defer sessA.Close()
+
+	chA, err := sessA.Open(context.Background())
+	fatal(err, t)
+	sessB := <-sessions
+	chB := <-accepted
+
+	// both ends close writing and read to the end, without calling Close
+	fatal(chA.CloseWrite(), t)
+	_, err = ioutil.ReadAll(chB)
+	fatal(err, t)
+	if n := sessB.(mux.StatsReporter).Stats().OpenChannels; n != 1 {
+		t.Fatalf("channel forgotten with writing open: %d", n)
+	}
+	fatal(chB.CloseWrite(), t)
+	_, err = ioutil.ReadAll(chA)
+	fatal(err, t)
+
+	for _, sess := range []mux.Session{sessA, sessB} {
+		if n := sess.(mux.StatsReporter).Stats().OpenChannels; n != 0 {
+			t.Fatalf("unexpected open channels: %d", n)
+		}
+	}
+}